@@ -0,0 +1,43 @@
+package linkterm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultACMECachePath returns where certificates obtained with ACME are
+// kept by default
+func DefaultACMECachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "linkterm", "acme"), nil
+}
+
+// StartACME is like StartTLS but obtains certificates for domains from
+// Let's Encrypt, renewing them before they expire. They are kept in cacheDir
+// across restarts, and email, if set, is given to Let's Encrypt for expiry
+// notices. Let's Encrypt proves control of the domains by connecting to
+// them on port 443, so that is where the server must be reachable.
+func (s *Server) StartACME(domains []string, cacheDir, email string) error {
+	if len(domains) == 0 {
+		return errors.New("no domains to obtain certificates for")
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	s.logger.Info().Strs("domains", domains).Str("cache", cacheDir).Msg("Obtaining certificates with ACME")
+	config := s.tlsConfig()
+	config.GetCertificate = manager.GetCertificate
+	// WebSocket upgrades need HTTP/1.1, the other answers ACME challenges
+	config.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	return s.startTLS(config)
+}
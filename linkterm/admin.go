@@ -0,0 +1,85 @@
+package linkterm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// adminURL turns a server URL as given to the client, e.g. host:8080 or
+// ws://host:8080/terminal, into the HTTP URL of endpoint on that server,
+// which sits next to the terminal endpoint as with endpointURL. Endpoint
+// may carry a query.
+func adminURL(server, endpoint string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	server, userinfo := cutUserinfo(server)
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	u.User = userinfo
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	endpoint, u.RawQuery, _ = strings.Cut(endpoint, "?")
+	u.Path = path.Join("/", path.Dir(u.Path), endpoint)
+	return u.String(), nil
+}
+
+// fetchAdmin gets path from a server's admin endpoints and returns the body
+func fetchAdmin(ctx context.Context, server, path string, header http.Header) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := requestAdmin(ctx, http.MethodGet, server, path, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+}
+
+// requestAdmin sends a method request for path to a server's admin endpoints,
+// returning the response if it succeeded for the caller to read and close
+func requestAdmin(ctx context.Context, method, server, path string, header http.Header) (*http.Response, error) {
+	endpoint, err := adminURL(server, path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("LinkTerm/%s %s", Version, Platform))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: %s", ErrAuthFailed, endpoint)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s not found, is the server running with the matching flag?", endpoint)
+	}
+	return nil, fmt.Errorf("%s returned HTTP %d", endpoint, resp.StatusCode)
+}
@@ -0,0 +1,160 @@
+package linkterm
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEvent is one record of an audit log. Each record carries the hash of
+// the one before it, so editing or removing a record breaks the chain.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Session   string    `json:"session,omitempty"`
+	ClientIP  string    `json:"clientIP,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Identity  string    `json:"identity,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// AuditLog appends hash-chained JSON records to a file
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	last string
+}
+
+// OpenAuditLog opens path for appending, continuing the hash chain of any
+// records already in it
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := verifyAuditChain(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("existing audit log %s is invalid: %w", path, err)
+	}
+	return &AuditLog{file: file, last: last}, nil
+}
+
+// Record appends event, filling in its time and hashes
+func (a *AuditLog) Record(event AuditEvent) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	// Records must hash the same after a round trip through JSON
+	event.Time = event.Time.UTC().Round(0)
+	event.Prev = a.last
+	event.Hash = ""
+	hash, err := auditHash(event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	a.last = hash
+	return nil
+}
+
+// Close closes the underlying file
+func (a *AuditLog) Close() error {
+	return a.file.Close()
+}
+
+// VerifyAuditLog checks the hash chain of the audit log at path, returning
+// the number of intact records
+func VerifyAuditLog(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	_, err = walkAuditChain(file, func() { count++ })
+	return count, err
+}
+
+// verifyAuditChain checks the records in r and returns the hash of the last one
+func verifyAuditChain(r io.Reader) (string, error) {
+	return walkAuditChain(r, func() {})
+}
+
+// walkAuditChain checks each record of r against its predecessor, calling
+// visit for every intact one
+func walkAuditChain(r io.Reader, visit func()) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	last := ""
+	for line := 1; scanner.Scan(); line++ {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return "", fmt.Errorf("line %d: %w", line, err)
+		}
+		if event.Prev != last {
+			return "", fmt.Errorf("line %d: chain broken, record does not follow the previous one", line)
+		}
+
+		claimed := event.Hash
+		event.Hash = ""
+		hash, err := auditHash(event)
+		if err != nil {
+			return "", err
+		}
+		if hash != claimed {
+			return "", fmt.Errorf("line %d: hash mismatch, record was modified", line)
+		}
+		last = hash
+		visit()
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return last, nil
+}
+
+// auditHash hashes an event whose Hash field is empty
+func auditHash(event AuditEvent) (string, error) {
+	if event.Hash != "" {
+		return "", errors.New("audit event already hashed")
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
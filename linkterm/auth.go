@@ -0,0 +1,81 @@
+package linkterm
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// no credentials of the kind it checks, so the next one may be tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator checks the credentials of a connection before its terminal
+// starts and returns the identity they prove
+type Authenticator func(r *http.Request) (identity string, err error)
+
+// AddAuthenticator requires every terminal connection to pass one of the
+// added authenticators. They are tried in order until one succeeds.
+func (s *Server) AddAuthenticator(auth Authenticator) {
+	s.authenticators = append(s.authenticators, auth)
+}
+
+// authenticate runs the authenticators against r. Without any, every
+// connection is accepted anonymously.
+func (s *Server) authenticate(r *http.Request) (string, error) {
+	if len(s.authenticators) == 0 {
+		return "", nil
+	}
+
+	var failure error
+	for _, auth := range s.authenticators {
+		identity, err := auth(r)
+		if err == nil {
+			return identity, nil
+		}
+		// Prefer reporting why presented credentials failed over missing ones
+		if failure == nil || !errors.Is(err, ErrNoCredentials) {
+			failure = err
+		}
+	}
+	return "", fmt.Errorf("%w: %w", ErrAuthFailed, failure)
+}
+
+// TokenAuth accepts connections presenting a shared token, either as an
+// "Authorization: Bearer" header or, for clients that cannot set headers, as
+// the access_token query parameter
+type TokenAuth struct {
+	Token string
+}
+
+// Authenticate implements Authenticator, the identity is "token"
+func (a TokenAuth) Authenticate(r *http.Request) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return "", ErrNoCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		return "", errors.New("token is invalid")
+	}
+	return "token", nil
+}
+
+// SetAuthToken requires terminal connections to present token, see TokenAuth.
+// Other authenticators added stay accepted alongside it.
+func (s *Server) SetAuthToken(token string) {
+	s.AddAuthenticator(TokenAuth{Token: token}.Authenticate)
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,144 @@
+package linkterm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// authzTimeout bounds how long an authorization program may take to decide
+const authzTimeout = 10 * time.Second
+
+// AuthorizationRequest describes an authenticated connection attempt
+type AuthorizationRequest struct {
+	ClientIP  string `json:"clientIP"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Identity is who the client authenticated as, empty without authentication
+	Identity string `json:"identity,omitempty"`
+	// Path is the endpoint asked for, such as /terminal or /forward
+	Path string `json:"path"`
+	// Session is the session the request is about, the one a client asks to
+	// reattach to or the one named in the path, such as /session/ID/history
+	Session string `json:"session,omitempty"`
+	// Owner is the identity that started Session, empty if it is unknown or
+	// was started anonymously
+	Owner string `json:"owner,omitempty"`
+}
+
+// Authorizer decides whether an authenticated connection may go on,
+// returning an error saying why not
+type Authorizer func(ctx context.Context, req AuthorizationRequest) error
+
+// SetAuthorizer has authorizer decide on every request to the server once it
+// passed authentication, from terminal connections to the stats, event,
+// watch, share and history endpoints
+func (s *Server) SetAuthorizer(authorizer Authorizer) {
+	s.authorizer = authorizer
+}
+
+// ExecAuthorizer returns an Authorizer running command through the shell for
+// each connection, with the request as JSON on its stdin. Exit status 0
+// allows the connection, anything else denies it, the first line the command
+// printed telling why. Its stderr goes to the server's.
+func ExecAuthorizer(command string) Authorizer {
+	return func(ctx context.Context, req AuthorizationRequest) error {
+		input, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, authzTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		}
+		cmd.Stdin = bytes.NewReader(append(input, '\n'))
+		// Children of a killed shell may hold its output open, stop waiting
+		cmd.WaitDelay = time.Second
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err == nil {
+			return nil
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || ctx.Err() != nil {
+			return fmt.Errorf("authorization command failed: %w", err)
+		}
+		reason, _, _ := bufio.NewReader(bytes.NewReader(output)).ReadLine()
+		if len(reason) == 0 {
+			return fmt.Errorf("denied by authorization command (exit status %d)", exitErr.ExitCode())
+		}
+		return errors.New(strings.TrimSpace(string(reason)))
+	}
+}
+
+// authorized asks the server's Authorizer whether the authenticated
+// connection r to path may go on, answering it with 403 Forbidden if not
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request, identity, path string) bool {
+	if s.authorizer == nil {
+		return true
+	}
+	req := AuthorizationRequest{
+		ClientIP:  getClientIP(r),
+		UserAgent: r.UserAgent(),
+		Identity:  identity,
+		Path:      path,
+		Session:   r.PathValue("id"),
+	}
+	if req.Session == "" {
+		req.Session = r.URL.Query().Get("session")
+	}
+	if req.Session != "" {
+		s.sessionsMu.Lock()
+		if sess := s.sessions[req.Session]; sess != nil {
+			req.Owner = sess.identity
+		}
+		s.sessionsMu.Unlock()
+	}
+	err := s.authorizer(r.Context(), req)
+	if err == nil {
+		return true
+	}
+	s.stats.countError("authz_denied")
+	s.logger.Warn().Str("clientIP", req.ClientIP).Str("identity", identity).Str("path", path).Err(err).Msg("Rejected connection, not authorized")
+	s.record(AuditEvent{Event: "connection_rejected", Session: req.Session, ClientIP: req.ClientIP, UserAgent: req.UserAgent, Path: path, Identity: identity, Detail: err.Error()})
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// admin answers the request with 403 Forbidden unless identity may use the
+// server's admin endpoints. Anonymous callers never may, others must be
+// listed in Admins or, without a list, have been let through by the
+// Authorizer.
+func (s *Server) admin(w http.ResponseWriter, r *http.Request, identity string) bool {
+	if s.isAdmin(identity) {
+		return true
+	}
+	clientIP := getClientIP(r)
+	s.logger.Warn().Str("clientIP", clientIP).Str("identity", identity).Str("path", r.URL.Path).Msg("Rejected admin request")
+	s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: r.UserAgent(), Path: r.URL.Path, Identity: identity, Detail: "not an admin"})
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// isAdmin reports whether identity, already authorized, is an admin
+func (s *Server) isAdmin(identity string) bool {
+	if identity == "" {
+		return false
+	}
+	if len(s.Admins) == 0 {
+		return s.authorizer != nil
+	}
+	return slices.Contains(s.Admins, identity)
+}
@@ -0,0 +1,104 @@
+package linkterm_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linksocks/linkterm/linkterm"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+// userAuth authenticates requests as the user in their X-User header
+func userAuth(r *http.Request) (string, error) {
+	if user := r.Header.Get("X-User"); user != "" {
+		return user, nil
+	}
+	return "", linkterm.ErrNoCredentials
+}
+
+func TestAuthorizerDecidesEveryRequest(t *testing.T) {
+	var mu sync.Mutex
+	var seen []linkterm.AuthorizationRequest
+	server := linkterm.NewServer(0, "", "sh")
+	server.Watch = true
+	server.History = true
+	server.ServeStats = true
+	server.ServeEvents = true
+	server.AddAuthenticator(userAuth)
+	server.SetAuthorizer(func(ctx context.Context, req linkterm.AuthorizationRequest) error {
+		mu.Lock()
+		seen = append(seen, req)
+		mu.Unlock()
+		if req.Path == linkterm.DefaultPath {
+			return nil
+		}
+		return errors.New("denied")
+	})
+	srv := linktermtest.NewServer(server, linktermtest.NewBackend(linktermtest.Echo))
+	defer srv.Close()
+	_, id := openSession(t, srv, http.Header{"X-User": {"alice"}})
+
+	bob := http.Header{"X-User": {"bob"}}
+	for _, path := range []string{"/stats", "/sessions", "/events", "/session/" + id + "/watch", "/session/" + id + "/history"} {
+		if status := request(t, srv, http.MethodGet, path, bob); status != http.StatusForbidden {
+			t.Errorf("GET %s denied by the authorizer: got status %d, want %d", path, status, http.StatusForbidden)
+		}
+	}
+	if status := request(t, srv, http.MethodPost, "/session/"+id+"/shares", bob); status != http.StatusForbidden {
+		t.Errorf("POST shares denied by the authorizer: got status %d, want %d", status, http.StatusForbidden)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var history *linkterm.AuthorizationRequest
+	for i := range seen {
+		if strings.HasSuffix(seen[i].Path, "/history") {
+			history = &seen[i]
+		}
+	}
+	if history == nil {
+		t.Fatal("authorizer was not asked about the history")
+	}
+	if history.Identity != "bob" || history.Session != id || history.Owner != "alice" {
+		t.Errorf("history request: got identity %q, session %q, owner %q, want bob, %s, alice", history.Identity, history.Session, history.Owner, id)
+	}
+}
+
+func TestExecAuthorizer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are Unix shell commands")
+	}
+	req := linkterm.AuthorizationRequest{ClientIP: "192.0.2.1", Identity: "alice", Path: "/terminal"}
+
+	if err := linkterm.ExecAuthorizer(`grep -q '"identity":"alice"'`)(context.Background(), req); err != nil {
+		t.Errorf("allowing command: %v", err)
+	}
+
+	err := linkterm.ExecAuthorizer("echo not on call; exit 1")(context.Background(), req)
+	if err == nil || err.Error() != "not on call" {
+		t.Errorf("denying command: got %v, want the reason it printed", err)
+	}
+
+	err = linkterm.ExecAuthorizer("exit 3")(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("silent denying command: got %v, want its exit status", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The sleep outlives its killed shell, holding the output open
+	err = linkterm.ExecAuthorizer("sleep 5 2>/dev/null")(ctx, req)
+	if err == nil || !strings.Contains(err.Error(), "authorization command failed") {
+		t.Errorf("command timing out: got %v, want a failure", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("command timing out took %s to deny", elapsed)
+	}
+}
@@ -0,0 +1,96 @@
+package linkterm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// Backend starts the programs behind terminal sessions. The default runs the
+// endpoint's command on a PTY, tests can substitute a fake one.
+type Backend interface {
+	Start(ep Endpoint) (Process, error)
+}
+
+// Process is a program started by a Backend. Reading returns its terminal
+// output and writing types into its terminal, Close closes the terminal.
+type Process interface {
+	io.ReadWriteCloser
+
+	// Resize sets the size of the terminal
+	Resize(cols, rows int) error
+
+	// Signal sends sig to the process, or to its process group if group is set
+	Signal(sig syscall.Signal, group bool) error
+
+	// Wait blocks until the process has exited
+	Wait() error
+}
+
+// ProcessUsage is the resources used by a process and its children
+type ProcessUsage struct {
+	CPUSeconds float64 `json:"cpuSeconds"`
+	RSSBytes   int64   `json:"rssBytes"`
+	Processes  int     `json:"processes"`
+}
+
+// UsageReporter is implemented by processes that can report their resource
+// usage, which the server includes in its session listing
+type UsageReporter interface {
+	// Usage reports the resources in use, false if they cannot be measured
+	Usage() (ProcessUsage, bool)
+}
+
+// PTYBackend runs endpoint commands on a PTY, inheriting the server's environment
+// along with the endpoint's variables
+type PTYBackend struct{}
+
+// Start spawns ep's command on a new PTY
+func (PTYBackend) Start(ep Endpoint) (Process, error) {
+	cmd := exec.Command(ep.ShellPath, ep.ShellArgs...)
+	cmd.Env = append(os.Environ(), ep.Env...)
+
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPTY, err)
+	}
+	return &ptyProcess{File: ptmx, cmd: cmd}, nil
+}
+
+// ptyProcess is a command running on a PTY, the embedded file being the
+// master side of the PTY
+type ptyProcess struct {
+	*os.File
+	cmd *exec.Cmd
+}
+
+// Resize sets the size of the PTY
+func (p *ptyProcess) Resize(cols, rows int) error {
+	return pty.Setsize(p.File, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+// Signal sends sig to the command, or its process group
+func (p *ptyProcess) Signal(sig syscall.Signal, group bool) error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+	return signalProcess(p.cmd.Process, sig, group)
+}
+
+// Wait reaps the command
+func (p *ptyProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+// Usage sums the resources used by the processes in the command's session,
+// which includes jobs started from the shell
+func (p *ptyProcess) Usage() (ProcessUsage, bool) {
+	if p.cmd.Process == nil {
+		return ProcessUsage{}, false
+	}
+	return processUsage(sessionMembers(p.cmd.Process.Pid))
+}
@@ -0,0 +1,91 @@
+package linkterm
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// BellNotify controls when a bell rung in the session raises a desktop
+// notification
+type BellNotify string
+
+const (
+	// BellNotifyOff leaves bells to the terminal
+	BellNotifyOff BellNotify = ""
+	// BellNotifyUnfocused notifies while the terminal window is not focused
+	BellNotifyUnfocused BellNotify = "unfocused"
+	// BellNotifyAlways notifies for every bell
+	BellNotifyAlways BellNotify = "always"
+)
+
+// ParseBellNotify validates a bell notification mode name
+func ParseBellNotify(name string) (BellNotify, error) {
+	switch mode := BellNotify(name); mode {
+	case BellNotifyOff, BellNotifyUnfocused, BellNotifyAlways:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown bell notification mode %q, expected unfocused or always", name)
+}
+
+// Focus reporting, xterm's mode 1004, makes the terminal type these
+// sequences when its window gains or loses focus
+var (
+	focusReportingOn  = []byte("\x1b[?1004h")
+	focusReportingOff = []byte("\x1b[?1004l")
+	focusIn           = []byte("\x1b[I")
+	focusOut          = []byte("\x1b[O")
+)
+
+// focusTracker follows whether the terminal window is focused, using focus
+// reports the client turned on. The reports are kept from the session
+// unless a program in it turned focus reporting on too.
+type focusTracker struct {
+	mu      sync.Mutex
+	focused bool
+	// remote is set while the session wants focus reports
+	remote bool
+}
+
+func newFocusTracker() *focusTracker {
+	return &focusTracker{focused: true}
+}
+
+// input records the focus reports in typed input and returns what to send
+// to the session
+func (f *focusTracker) input(p []byte) []byte {
+	in, out := bytes.LastIndex(p, focusIn), bytes.LastIndex(p, focusOut)
+	if in < 0 && out < 0 {
+		return p
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.focused = in > out
+	if f.remote {
+		return p
+	}
+	p = bytes.ReplaceAll(p, focusIn, nil)
+	return bytes.ReplaceAll(p, focusOut, nil)
+}
+
+// output follows the session turning focus reporting on and off, reporting
+// true when it was turned off and the client must turn it back on
+func (f *focusTracker) output(p []byte) bool {
+	on, off := bytes.LastIndex(p, focusReportingOn), bytes.LastIndex(p, focusReportingOff)
+	if on < 0 && off < 0 {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.remote = on > off
+	return !f.remote
+}
+
+// isFocused reports whether the terminal window was last seen focused
+func (f *focusTracker) isFocused() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.focused
+}
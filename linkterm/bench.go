@@ -0,0 +1,209 @@
+package linkterm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// EchoPath is where servers with Echo set serve the benchmark echo endpoint
+const EchoPath = "/echo"
+
+// benchWindow bounds the bytes in flight while measuring throughput, so the
+// measurement reflects the path rather than how much the buffers can hold
+const benchWindow = 1 << 20
+
+// benchStall is how long a benchmark waits for an echo before giving up
+const benchStall = 30 * time.Second
+
+// handleEcho sends every message back to the client unchanged, for
+// measuring latency and throughput with `linkterm bench`
+func (s *Server) handleEcho(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected echo connection")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, EchoPath) {
+		return
+	}
+
+	wsConn, err := s.upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		return
+	}
+	defer wsConn.Close()
+	if s.MaxMessageSize > 0 {
+		wsConn.SetReadLimit(s.MaxMessageSize)
+	}
+
+	s.logger.Info().Str("clientIP", clientIP).Msg("Echo client connected")
+	defer s.logger.Info().Str("clientIP", clientIP).Msg("Echo client disconnected")
+	for {
+		messageType, p, err := wsConn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := wsConn.WriteMessage(messageType, p); err != nil {
+			return
+		}
+	}
+}
+
+// BenchOptions configures a benchmark run against an echo endpoint
+type BenchOptions struct {
+	// Count round trips of Size bytes are timed one after another
+	Count int
+	Size  int
+
+	// Duration is how long to stream ChunkSize messages to measure
+	// throughput, 0 skips the throughput test
+	Duration  time.Duration
+	ChunkSize int
+}
+
+// BenchResult holds the measurements of a benchmark run
+type BenchResult struct {
+	// Latencies are the round-trip times, sorted
+	Latencies []time.Duration
+
+	// Bytes is how much data made the round trip during the throughput
+	// test, in Elapsed
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+// Percentile returns the round-trip time below which p percent fall
+func (r BenchResult) Percentile(p float64) time.Duration {
+	return Percentile(r.Latencies, p)
+}
+
+// Percentile returns the duration below which p percent of the sorted
+// durations fall
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p/100)]
+}
+
+// Throughput returns the round-trip throughput in bytes per second
+func (r BenchResult) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// Bench connects to the echo endpoint of the server at the client's URL and
+// measures round-trip latency and throughput through the client's dialer
+func (c *Client) Bench(opts BenchOptions) (BenchResult, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return BenchResult{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	u.Path = EchoPath
+	u.RawQuery = ""
+
+	conn, _, err := c.open(u.String())
+	if err != nil {
+		return BenchResult{}, err
+	}
+	defer conn.Close()
+
+	var result BenchResult
+	if result.Latencies, err = benchLatency(conn, opts.Count, max(opts.Size, 1)); err != nil {
+		return result, err
+	}
+	if opts.Duration > 0 {
+		if result.Bytes, result.Elapsed, err = benchThroughput(conn, opts.Duration, max(opts.ChunkSize, 1)); err != nil {
+			return result, err
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return result, nil
+}
+
+// benchLatency times count sequential round trips of size byte messages
+func benchLatency(conn *websocket.Conn, count, size int) ([]time.Duration, error) {
+	latencies := make([]time.Duration, 0, count)
+	payload := make([]byte, size)
+	var seq [8]byte
+	for i := 0; i < count; i++ {
+		// Numbering the messages catches echoes arriving out of order
+		binary.BigEndian.PutUint64(seq[:], uint64(i))
+		copy(payload, seq[:])
+
+		start := time.Now()
+		if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			return nil, err
+		}
+		_, echo, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		latencies = append(latencies, time.Since(start))
+
+		if !bytes.Equal(echo, payload) {
+			return nil, errors.New("echo does not match what was sent")
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies, nil
+}
+
+// benchThroughput streams chunk byte messages for duration, keeping at most
+// benchWindow bytes unacknowledged, and returns how much came back and when
+// the last echo arrived. A text message marks the end of the stream.
+func benchThroughput(conn *websocket.Conn, duration time.Duration, chunk int) (int64, time.Duration, error) {
+	window := make(chan struct{}, max(benchWindow/chunk, 1))
+	payload := make([]byte, chunk)
+
+	writeErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	start := time.Now()
+	go func() {
+		deadline := start.Add(duration)
+		for time.Now().Before(deadline) {
+			select {
+			case window <- struct{}{}:
+			case <-stop:
+				return
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- conn.WriteMessage(websocket.TextMessage, []byte("end"))
+	}()
+
+	var received int64
+	for {
+		// A path that stops echoing for this long is not going to recover
+		conn.SetReadDeadline(time.Now().Add(benchStall))
+		messageType, echo, err := conn.ReadMessage()
+		if err != nil {
+			return received, time.Since(start), fmt.Errorf("echo stopped while measuring throughput: %w", err)
+		}
+		if messageType == websocket.TextMessage {
+			break
+		}
+		received += int64(len(echo))
+		<-window
+	}
+	elapsed := time.Since(start)
+	conn.SetReadDeadline(time.Time{})
+	return received, elapsed, <-writeErr
+}
@@ -0,0 +1,46 @@
+package linkterm
+
+import "testing"
+
+func TestCheckEndpoints(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		ok    bool
+	}{
+		{"distinct", []string{"/db=psql mydb", "/logs=journalctl -f"}, true},
+		{"duplicate", []string{"/db=psql mydb", "/db=mysql"}, false},
+		{"terminal", []string{"/terminal=bash"}, false},
+		{"stats", []string{"/stats=top"}, false},
+		{"sessions", []string{"/sessions=top"}, false},
+		{"events", []string{"/events=top"}, false},
+		{"session", []string{"/session/x/watch=top"}, false},
+		{"forward", []string{"/forward=top"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var eps []Endpoint
+			for _, spec := range tt.specs {
+				ep, err := parseEndpoint(spec, false)
+				if err != nil {
+					t.Fatalf("parseEndpoint(%q): %v", spec, err)
+				}
+				eps = append(eps, ep)
+			}
+			err := checkEndpoints(DefaultPath, eps)
+			if tt.ok && err != nil {
+				t.Errorf("checkEndpoints: %v", err)
+			} else if !tt.ok && err == nil {
+				t.Error("checkEndpoints accepted a path already taken")
+			}
+		})
+	}
+}
+
+func TestParseEndpointInvalidPath(t *testing.T) {
+	for _, spec := range []string{"/a b=top", "/{id}=top", "db=psql", "/db="} {
+		if _, err := parseEndpoint(spec, false); err == nil {
+			t.Errorf("parseEndpoint(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package linkterm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ClipboardPath is where servers with Clipboard set let clients push to and
+// pull from the clipboard of the desktop the server runs in. The op query
+// parameter is push, the client then sending the contents in a binary
+// message, or pull, the server sending them. The server closes the
+// connection normally once done, with the reason of a failure otherwise.
+const ClipboardPath = "/clipboard"
+
+const (
+	// maxClipboardSize bounds the clipboard contents moved either way
+	maxClipboardSize = 16 << 20
+	// clipboardTimeout bounds how long a clipboard tool may run
+	clipboardTimeout = 10 * time.Second
+	// maxCloseReason is the longest reason a close message can carry
+	maxCloseReason = 123
+)
+
+// ReadClipboard returns the contents of the system clipboard
+func ReadClipboard(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, clipboardTimeout)
+	defer cancel()
+	cmd, err := clipboardCommand(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, clipboardError(err, stderr.String())
+	}
+	if len(data) > maxClipboardSize {
+		return nil, fmt.Errorf("clipboard holds more than %d bytes", maxClipboardSize)
+	}
+	return data, nil
+}
+
+// WriteClipboard replaces the contents of the system clipboard
+func WriteClipboard(ctx context.Context, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, clipboardTimeout)
+	defer cancel()
+	cmd, err := clipboardCommand(ctx, true)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return clipboardError(err, stderr.String())
+	}
+	return nil
+}
+
+// clipboardError adds what a failed clipboard tool printed to its error
+func clipboardError(err error, stderr string) error {
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("clipboard: %w: %s", err, stderr)
+	}
+	return fmt.Errorf("clipboard: %w", err)
+}
+
+// closeReason cuts reason to what a close message can carry
+func closeReason(reason string) string {
+	if len(reason) > maxCloseReason {
+		// Without splitting a character, as the reason must be UTF-8
+		reason = reason[:maxCloseReason]
+		return reason[:len(reason)-partialRune([]byte(reason))]
+	}
+	return reason
+}
+
+// handleClipboard moves the contents of the server's clipboard to or from
+// the client
+func (s *Server) handleClipboard(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	userAgent := r.UserAgent()
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.stats.countError("auth_failed")
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected clipboard access, authentication failed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ClipboardPath, Detail: err.Error()})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, ClipboardPath) {
+		return
+	}
+
+	op := r.URL.Query().Get("op")
+	if op != "push" && op != "pull" {
+		http.Error(w, "op must be push or pull", http.StatusBadRequest)
+		return
+	}
+
+	header := make(http.Header)
+	if s.HostKey != nil {
+		s.signHandshake(r, header)
+	}
+	conn, err := s.upgrade(w, r, header)
+	if err != nil {
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		s.stats.countError("upgrade")
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxClipboardSize)
+
+	var data []byte
+	if op == "push" {
+		_, data, err = conn.ReadMessage()
+		if err == nil {
+			err = WriteClipboard(r.Context(), data)
+		}
+	} else {
+		if data, err = ReadClipboard(r.Context()); err == nil {
+			err = conn.WriteMessage(websocket.BinaryMessage, data)
+		}
+	}
+	if err != nil {
+		s.logger.Warn().Str("clientIP", clientIP).Str("op", op).Err(err).Msg("Clipboard transfer failed")
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, closeReason(err.Error())))
+		return
+	}
+
+	s.logger.Info().Str("clientIP", clientIP).Str("op", op).Int("bytes", len(data)).Str("identity", identity).Msg("Clipboard transferred")
+	s.record(AuditEvent{Event: "clipboard_" + op, ClientIP: clientIP, UserAgent: userAgent, Path: ClipboardPath, Identity: identity, Detail: strconv.Itoa(len(data)) + " bytes"})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// PushClipboard replaces the contents of the server's clipboard with data.
+// The server must allow Clipboard.
+func (c *Client) PushClipboard(ctx context.Context, data []byte) error {
+	if len(data) > maxClipboardSize {
+		return fmt.Errorf("clipboard contents over %d bytes", maxClipboardSize)
+	}
+	conn, err := c.openClipboard(ctx, "push")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return err
+	}
+	return clipboardDone(conn)
+}
+
+// PullClipboard returns the contents of the server's clipboard. The server
+// must allow Clipboard.
+func (c *Client) PullClipboard(ctx context.Context) ([]byte, error) {
+	conn, err := c.openClipboard(ctx, "pull")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxClipboardSize)
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, clipboardResult(err)
+	}
+	return data, clipboardDone(conn)
+}
+
+// openClipboard connects to the server's clipboard endpoint for op
+func (c *Client) openClipboard(ctx context.Context, op string) (*websocket.Conn, error) {
+	dialer, header := c.handshake()
+	target, err := endpointURL(c.URL, ClipboardPath, url.Values{"op": {op}})
+	if err != nil {
+		return nil, err
+	}
+	conn, resp, err := dialer.DialContext(ctx, target, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("%w: server returned HTTP 401", ErrAuthFailed)
+		} else if resp != nil {
+			return nil, fmt.Errorf("server refused clipboard access: HTTP %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to terminal server: %w: %w", ErrHandshake, err)
+	}
+	if err := c.checkHostKey(target, "", resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// clipboardDone waits for the server to close the connection, returning the
+// reason it gives for a failure
+func clipboardDone(conn *websocket.Conn) error {
+	_, _, err := conn.ReadMessage()
+	return clipboardResult(err)
+}
+
+// clipboardResult turns the error ending a clipboard connection into the
+// outcome of the transfer
+func clipboardResult(err error) error {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		if closeErr.Code == websocket.CloseNormalClosure {
+			return nil
+		}
+		return fmt.Errorf("server: %s", closeErr.Text)
+	}
+	return fmt.Errorf("connection lost: %w", err)
+}
@@ -0,0 +1,16 @@
+//go:build darwin
+
+package linkterm
+
+import (
+	"context"
+	"os/exec"
+)
+
+// clipboardCommand returns pbpaste, or pbcopy if write is set
+func clipboardCommand(ctx context.Context, write bool) (*exec.Cmd, error) {
+	if write {
+		return exec.CommandContext(ctx, "pbcopy"), nil
+	}
+	return exec.CommandContext(ctx, "pbpaste"), nil
+}
@@ -0,0 +1,33 @@
+//go:build !darwin && !windows
+
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// clipboardCommand returns the command reading the clipboard, or writing it
+// from its input if write is set, with the first of wl-clipboard, xclip and
+// xsel found
+func clipboardCommand(ctx context.Context, write bool) (*exec.Cmd, error) {
+	tools := [][2][]string{
+		{{"xclip", "-selection", "clipboard", "-o"}, {"xclip", "-selection", "clipboard", "-i"}},
+		{{"xsel", "--clipboard", "--output"}, {"xsel", "--clipboard", "--input"}},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append([][2][]string{{{"wl-paste", "--no-newline"}, {"wl-copy"}}}, tools...)
+	}
+	for _, tool := range tools {
+		args := tool[0]
+		if write {
+			args = tool[1]
+		}
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return exec.CommandContext(ctx, args[0], args[1:]...), nil
+		}
+	}
+	return nil, errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel")
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package linkterm
+
+import (
+	"context"
+	"os/exec"
+)
+
+// clipboardCommand returns PowerShell's Get-Clipboard, or Set-Clipboard
+// taking the text from its input if write is set
+func clipboardCommand(ctx context.Context, write bool) (*exec.Cmd, error) {
+	if write {
+		return exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", "Set-Clipboard -Value ([Console]::In.ReadToEnd())"), nil
+	}
+	return exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"), nil
+}
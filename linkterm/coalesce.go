@@ -0,0 +1,213 @@
+package linkterm
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxOutputQueue bounds the terminal output read ahead while clients are
+// still being sent earlier output, the terminal is not read beyond it
+const maxOutputQueue = 256 * 1024
+
+// outputQueue carries terminal output to the goroutine delivering it, so the
+// terminal can be read while a slow client is being written to
+type outputQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	data []byte
+	// reads counts the reads gathered in data, more than one meaning
+	// delivery fell behind
+	reads  int
+	closed bool
+}
+
+func newOutputQueue() *outputQueue {
+	q := &outputQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// put adds output to the queue, waiting while the queue is full
+func (q *outputQueue) put(data []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.data) >= maxOutputQueue {
+		q.cond.Wait()
+	}
+	q.data = append(q.data, data...)
+	q.reads++
+	q.cond.Broadcast()
+}
+
+// close tells take no more output will follow
+func (q *outputQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// take waits for output and returns all of it, reporting whether it piled up
+// over several reads. It returns nil once the queue is closed and empty.
+func (q *outputQueue) take() (data []byte, behind bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.data) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	data, behind = q.data, q.reads > 1
+	q.data, q.reads = nil, 0
+	q.cond.Broadcast()
+	return data, behind
+}
+
+// frameCoalescer drops screen updates superseded by later ones from output
+// that piled up. Only full-screen apps on the alternate screen are taken to
+// redraw all of it after clearing it, output to the normal screen is kept for
+// its scrollback.
+type frameCoalescer struct {
+	// altScreen is whether output goes to the alternate screen
+	altScreen bool
+	// partial is an escape sequence cut off at the end of the last output,
+	// to tell what it does once complete
+	partial []byte
+}
+
+// coalesce returns data with the frames before the last full-screen clear
+// dropped if behind is set, keeping the changes of modes and attributes they
+// made. It must see all output in order, to know which screen is in use.
+func (f *frameCoalescer) coalesce(data []byte, behind bool) []byte {
+	// A sequence cut off last time is scanned again whole, but never dropped
+	held := len(f.partial)
+	buf := append(f.partial, data...)
+	f.partial = nil
+
+	// The alternate screen must stay in use from start to the clear
+	start, clear := -1, -1
+	if f.altScreen {
+		start = held
+	}
+	for i := 0; i < len(buf); {
+		if buf[i] != 0x1b {
+			i++
+			continue
+		}
+		n, complete := escapeLength(buf[i:])
+		if !complete {
+			if len(buf)-i <= maxHeldCSI {
+				f.partial = append([]byte(nil), buf[i:]...)
+			}
+			break
+		}
+		seq := buf[i : i+n]
+		if alt, ok := altScreenSwitch(seq); ok {
+			f.altScreen = alt
+			start = -1
+			if alt {
+				start = max(i+n, held)
+			}
+		} else if f.altScreen && start >= 0 && i >= start {
+			if m := clearsScreen(buf[i:]); m > 0 {
+				clear = i
+				n = m
+			}
+		}
+		i += n
+	}
+
+	if !behind || start < 0 || clear <= start {
+		return data
+	}
+	start, clear = start-held, clear-held
+	out := make([]byte, 0, len(data)-(clear-start))
+	out = append(out, data[:start]...)
+	out = append(out, screenState(data[start:clear])...)
+	return append(out, data[clear:]...)
+}
+
+// altScreenSwitch reports whether seq switches to the alternate screen or
+// back, and which
+func altScreenSwitch(seq []byte) (alt bool, ok bool) {
+	if len(seq) < 4 || seq[1] != '[' || seq[2] != '?' {
+		return false, false
+	}
+	final := seq[len(seq)-1]
+	if final != 'h' && final != 'l' {
+		return false, false
+	}
+	for _, mode := range strings.Split(string(seq[3:len(seq)-1]), ";") {
+		if mode == "1049" || mode == "1047" || mode == "47" {
+			return final == 'h', true
+		}
+	}
+	return false, false
+}
+
+// screenClears are the sequences erasing the whole screen, either directly
+// or by going home and erasing everything below
+var screenClears = [][]byte{
+	[]byte("\x1b[2J"),
+	[]byte("\x1b[H\x1b[J"), []byte("\x1b[H\x1b[0J"), []byte("\x1b[H\x1b[2J"),
+	[]byte("\x1b[1;1H\x1b[J"), []byte("\x1b[1;1H\x1b[0J"), []byte("\x1b[1;1H\x1b[2J"),
+}
+
+// clearsScreen returns the length of the screen clearing sequence p starts
+// with, 0 if it starts with none
+func clearsScreen(p []byte) int {
+	for _, clear := range screenClears {
+		if bytes.HasPrefix(p, clear) {
+			return len(clear)
+		}
+	}
+	return 0
+}
+
+// screenState returns the sequences of dropped output whose effect outlasts
+// a screen clear: mode, charset, title and attribute changes, bells and
+// cursor saves. Text, cursor movement and erasing are left out.
+func screenState(dropped []byte) []byte {
+	var kept [][]byte
+	for i := 0; i < len(dropped); {
+		c := dropped[i]
+		if c == '\a' {
+			kept = append(kept, dropped[i:i+1])
+		}
+		if c != 0x1b {
+			i++
+			continue
+		}
+		n, complete := escapeLength(dropped[i:])
+		if !complete {
+			break
+		}
+		seq := dropped[i : i+n]
+		i += n
+		if n < 2 {
+			continue
+		}
+		switch seq[1] {
+		case '[':
+			params, final := string(seq[2:len(seq)-1]), seq[len(seq)-1]
+			switch {
+			case final == 'm' && (params == "" || params == "0"):
+				// A reset undoes the attributes set before it
+				kept = slices.DeleteFunc(kept, isSGR)
+			case final == 'm', final == 'r', final == 'h', final == 'l', final == 'q' && strings.HasSuffix(params, " "):
+			default:
+				continue
+			}
+		case '8', 'D', 'E', 'M':
+			// Cursor restores and movements
+			continue
+		}
+		kept = append(kept, seq)
+	}
+	return bytes.Join(kept, nil)
+}
+
+// isSGR reports whether seq is a select graphic rendition sequence
+func isSGR(seq []byte) bool {
+	return len(seq) > 2 && seq[1] == '[' && seq[len(seq)-1] == 'm'
+}
@@ -0,0 +1,26 @@
+package linkterm
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// safeConn serializes writes to a WebSocket connection, since gorilla/websocket
+// supports only one concurrent writer
+type safeConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+// newSafeConn wraps conn for use from multiple writing goroutines
+func newSafeConn(conn *websocket.Conn) *safeConn {
+	return &safeConn{Conn: conn}
+}
+
+// WriteMessage writes a message, waiting for any concurrent writer to finish
+func (c *safeConn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
@@ -0,0 +1,204 @@
+package linkterm
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// passphraseEnv supplies the credential store passphrase without a prompt
+const passphraseEnv = "LINKTERM_PASSPHRASE"
+
+// credentialFile is the on-disk form of a credential store. The credentials
+// are a JSON object sealed with XChaCha20-Poly1305 under a key derived from
+// the passphrase with scrypt.
+type credentialFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// CredentialStore holds named secrets such as tokens and proxy passwords in
+// an encrypted file, so they stay out of config files and shell history
+type CredentialStore struct {
+	path        string
+	passphrase  []byte
+	credentials map[string]string
+}
+
+// DefaultCredentialStorePath returns where the credential store lives by default
+func DefaultCredentialStorePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "linkterm", "credentials"), nil
+}
+
+// OpenCredentialStore decrypts the store at path with passphrase. A missing
+// file opens as an empty store, created on Save.
+func OpenCredentialStore(path string, passphrase []byte) (*CredentialStore, error) {
+	store := &CredentialStore{path: path, passphrase: passphrase, credentials: map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file credentialFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid credential store: %w", err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("unsupported credential store version %d", file.Version)
+	}
+
+	aead, err := credentialCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted credential store")
+	}
+	if err := json.Unmarshal(plain, &store.credentials); err != nil {
+		return nil, fmt.Errorf("invalid credential store contents: %w", err)
+	}
+	return store, nil
+}
+
+// Get returns the credential stored under name
+func (c *CredentialStore) Get(name string) (string, bool) {
+	value, ok := c.credentials[name]
+	return value, ok
+}
+
+// Set stores value under name, replacing any previous value
+func (c *CredentialStore) Set(name, value string) {
+	c.credentials[name] = value
+}
+
+// Delete removes the credential stored under name
+func (c *CredentialStore) Delete(name string) bool {
+	_, ok := c.credentials[name]
+	delete(c.credentials, name)
+	return ok
+}
+
+// Names returns the names of all stored credentials
+func (c *CredentialStore) Names() []string {
+	names := make([]string, 0, len(c.credentials))
+	for name := range c.credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the store with a fresh salt and nonce and writes it back
+func (c *CredentialStore) Save() error {
+	plain, err := json.Marshal(c.credentials)
+	if err != nil {
+		return err
+	}
+
+	file := credentialFile{Version: 1, Salt: make([]byte, 16), Nonce: make([]byte, chacha20poly1305.NonceSizeX)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	aead, err := credentialCipher(c.passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+
+	// Replace the file atomically so a failed write cannot lose the store
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// credentialCipher derives the store's key from passphrase and salt
+func credentialCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+var (
+	defaultStoreOnce sync.Once
+	defaultStore     *CredentialStore
+	defaultStoreErr  error
+)
+
+// openDefaultCredentialStore opens the default store once per run, taking the
+// passphrase from LINKTERM_PASSPHRASE or asking for it on the terminal
+func openDefaultCredentialStore() (*CredentialStore, error) {
+	defaultStoreOnce.Do(func() {
+		path, err := DefaultCredentialStorePath()
+		if err != nil {
+			defaultStoreErr = err
+			return
+		}
+		passphrase, err := readPassphrase("Credential store passphrase: ")
+		if err != nil {
+			defaultStoreErr = err
+			return
+		}
+		defaultStore, defaultStoreErr = OpenCredentialStore(path, passphrase)
+	})
+	return defaultStore, defaultStoreErr
+}
+
+// readPassphrase returns LINKTERM_PASSPHRASE if set, or prompts for a
+// passphrase without echoing it
+func readPassphrase(prompt string) ([]byte, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	passphrase, err := readHidden(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("%w, or set %s", err, passphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// readHidden prompts on stderr and reads a line from the terminal without echo
+func readHidden(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no terminal to prompt on")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	value, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return value, err
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+
+package linkterm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// daemonEnv marks re-executed processes with their stage in daemonizing
+const daemonEnv = "LINKTERM_DAEMON"
+
+// daemonize detaches the server from the terminal with the classic double fork,
+// done by re-executing ourselves as Go cannot fork. The first child starts a new
+// session and immediately spawns the actual daemon, which as a non-leader can
+// never reacquire a controlling terminal. Output goes to logFile, or is
+// discarded if empty. It returns true in every process that should exit now.
+func daemonize(logFile string) (bool, error) {
+	switch os.Getenv(daemonEnv) {
+	case "2":
+		// The daemon itself
+		os.Unsetenv(daemonEnv)
+		return false, nil
+	case "1":
+		// Session leader, fork once more and leave
+		return true, spawnSelf("2", false, os.Stdout)
+	}
+
+	output, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if logFile != "" {
+		output, err = os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer output.Close()
+
+	if err := spawnSelf("1", true, output); err != nil {
+		return false, err
+	}
+	fmt.Println("Server started in the background")
+	return true, nil
+}
+
+// spawnSelf re-executes the running binary with the same arguments at the given
+// daemonizing stage, writing its output to output
+func spawnSelf(stage string, setsid bool, output *os.File) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"="+stage)
+	cmd.Stdin = devNull
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: setsid}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start background process: %w", err)
+	}
+	return cmd.Process.Release()
+}
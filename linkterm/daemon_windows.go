@@ -0,0 +1,10 @@
+//go:build windows
+
+package linkterm
+
+import "fmt"
+
+// daemonize is not available on Windows, where services take its place
+func daemonize(logFile string) (bool, error) {
+	return false, fmt.Errorf("--daemon is not supported on Windows")
+}
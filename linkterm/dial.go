@@ -0,0 +1,155 @@
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// AddressFamily restricts the IP versions the client connects over
+type AddressFamily int
+
+const (
+	// FamilyAny races IPv6 and IPv4 addresses, preferring IPv6
+	FamilyAny AddressFamily = iota
+	// FamilyIPv4 connects over IPv4 only
+	FamilyIPv4
+	// FamilyIPv6 connects over IPv6 only
+	FamilyIPv6
+)
+
+// connectionAttemptDelay is how long an attempt gets before the next address
+// is tried in parallel, as recommended by RFC 8305
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// happyEyeballsDialer dials TCP addresses following RFC 8305: all addresses of
+// a host are resolved, interleaved by family starting with IPv6, and tried
+// with staggered parallel attempts. The first connection to succeed wins.
+type happyEyeballsDialer struct {
+	family AddressFamily
+	dialer net.Dialer
+}
+
+// DialContext connects to addr, a host:port pair
+func (d *happyEyeballsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	pending := 0
+	var errs []error
+
+	// The first attempt starts right away, each next one after a delay or as
+	// soon as the previous attempt has failed
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for next := 0; next < len(ips) || pending > 0; {
+		var start <-chan time.Time
+		if next < len(ips) {
+			start = timer.C
+		}
+
+		select {
+		case <-start:
+			target := net.JoinHostPort(ips[next].String(), port)
+			next++
+			pending++
+			go func() {
+				conn, err := d.dialer.DialContext(ctx, "tcp", target)
+				results <- result{conn, err}
+			}()
+			timer.Reset(connectionAttemptDelay)
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close connections of attempts that succeed after the winner
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			timer.Reset(0)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// resolve returns the addresses of host allowed by the family, interleaved
+// IPv6 first
+func (d *happyEyeballsDialer) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	var addrs []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = []net.IP{ip}
+	} else {
+		resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range resolved {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+
+	var v6, v4 []net.IP
+	for _, ip := range addrs {
+		if ip.To4() != nil {
+			if d.family != FamilyIPv6 {
+				v4 = append(v4, ip)
+			}
+		} else if d.family != FamilyIPv4 {
+			v6 = append(v6, ip)
+		}
+	}
+	if len(v6)+len(v4) == 0 {
+		return nil, fmt.Errorf("no address of %s matches the requested address family", host)
+	}
+
+	ips := make([]net.IP, 0, len(v6)+len(v4))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ips = append(ips, v6[i])
+		}
+		if i < len(v4) {
+			ips = append(ips, v4[i])
+		}
+	}
+	return ips, nil
+}
+
+// urlHostPort returns the host:port a WebSocket URL connects to, with the
+// scheme's default port if it names none
+func urlHostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" || u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,71 @@
+package linkterm
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// EnvHeader carries the environment variables a client sends for its shell,
+// query encoded. Servers only pass on those AcceptEnv allows.
+const EnvHeader = "X-LinkTerm-Env"
+
+// withEnv returns header with the local variables matching SendEnv added,
+// leaving header itself alone
+func (c *Client) withEnv(header http.Header) http.Header {
+	env := make(url.Values)
+	for _, variable := range os.Environ() {
+		if name, value, ok := strings.Cut(variable, "="); ok && name != "" && matchEnv(c.SendEnv, name) {
+			env.Set(name, value)
+		}
+	}
+	if len(env) == 0 {
+		return header
+	}
+	header = header.Clone()
+	header.Set(EnvHeader, env.Encode())
+	return header
+}
+
+// acceptedEnv returns the variables sent with r that AcceptEnv allows, as
+// NAME=VALUE
+func (s *Server) acceptedEnv(r *http.Request) []string {
+	if len(s.AcceptEnv) == 0 {
+		return nil
+	}
+	env, err := url.ParseQuery(r.Header.Get(EnvHeader))
+	if err != nil {
+		s.logger.Debug().Err(err).Msg("Ignored malformed environment from client")
+		return nil
+	}
+
+	var accepted, refused []string
+	for name, values := range env {
+		value := values[0]
+		if name == "" || strings.ContainsAny(name, "=\x00") || strings.Contains(value, "\x00") || !matchEnv(s.AcceptEnv, name) {
+			refused = append(refused, name)
+			continue
+		}
+		accepted = append(accepted, name+"="+value)
+	}
+	sort.Strings(accepted)
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		s.logger.Debug().Strs("names", refused).Msg("Ignored environment variables not accepted")
+	}
+	return accepted
+}
+
+// matchEnv reports whether a variable name matches one of patterns, such as
+// "GIT_AUTHOR_*" or "EDITOR"
+func matchEnv(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package linkterm_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linksocks/linkterm/linkterm"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+func TestEndpointEnvKept(t *testing.T) {
+	server := linkterm.NewServer(0, "", "sh")
+	server.AddEndpoint(linkterm.Endpoint{Path: "/db", ShellPath: "psql", Env: []string{"PGDATABASE=app"}})
+	backend := linktermtest.NewBackend(linktermtest.Echo)
+	srv := linktermtest.NewServer(server, backend)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for range 2 {
+		conn, _, err := srv.Dialer().DialContext(ctx, "ws://linkterm.test/db", nil)
+		if err != nil {
+			t.Fatalf("dial endpoint: %v", err)
+		}
+		defer conn.Close()
+		p, err := backend.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := p.Endpoint.Env
+		if !slices.Contains(env, "PGDATABASE=app") {
+			t.Errorf("endpoint variable missing from %q", env)
+		}
+		if !slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "LINKTERM_CLIENT_IP=") }) {
+			t.Errorf("LINKTERM_CLIENT_IP missing from %q", env)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package linkterm
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+)
+
+// Errors returned by the package, wrapped with details. Match them with errors.Is.
+var (
+	// ErrAuthFailed means the server refused the client, by credentials or by its operator
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrHandshake means the WebSocket connection to the server could not be established
+	ErrHandshake = errors.New("handshake failed")
+	// ErrHostKey means the server's host key is unknown, changed or did not sign the handshake
+	ErrHostKey = errors.New("host key verification failed")
+	// ErrProxy means the proxy or tunnel in front of the server failed
+	ErrProxy = errors.New("proxy error")
+	// ErrPTY means the pseudo-terminal of a shell could not be started or used
+	ErrPTY = errors.New("pty error")
+	// ErrSessionClosed means the session or its connection has already ended
+	ErrSessionClosed = errors.New("session closed")
+)
+
+// CloseShellFailed is the WebSocket close code the server ends a connection
+// with when the session's shell could not be started, the reason saying why
+const CloseShellFailed = 4001
+
+// isClosedErr reports whether err only says the connection was closed by us
+func isClosedErr(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, websocket.ErrCloseSent) || errors.Is(err, ErrSessionClosed)
+}
+
+// isPTYClosedErr reports whether err is the PTY reporting that the shell side went away
+func isPTYClosedErr(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed)
+}
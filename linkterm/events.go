@@ -0,0 +1,108 @@
+package linkterm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is something that happened to a client's session, one of
+// ConnectedEvent, ReconnectingEvent, ResizedEvent, TitleChangedEvent,
+// NotificationEvent, NoticeEvent and ClosedEvent
+type Event interface {
+	event()
+}
+
+// ConnectedEvent is sent once the connection to the server is established
+type ConnectedEvent struct {
+	URL string
+	// Server is the server's hello, nil for servers without FeatureHello
+	Server *Hello
+}
+
+// ReconnectingEvent is sent when a connection attempt failed and is retried
+// after Delay
+type ReconnectingEvent struct {
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+// ResizedEvent is sent once the remote terminal has taken a new size
+type ResizedEvent struct {
+	Cols, Rows int
+}
+
+// TitleChangedEvent is sent when a program in the session sets the window title
+type TitleChangedEvent struct {
+	Title string
+}
+
+// NotificationEvent is sent when a program in the session asks for a desktop
+// notification with OSC 9 or OSC 777. Title is empty for OSC 9.
+type NotificationEvent struct {
+	Title string
+	Body  string
+}
+
+// NoticeEvent is sent when the server has something to tell the user, such
+// as that the session is about to time out
+type NoticeEvent struct {
+	Text string
+}
+
+// ClosedEvent is the last event of a session. ExitCode is the shell's exit
+// status if the server reported it, -1 otherwise. BytesSent and
+// BytesReceived count the terminal input and output carried.
+type ClosedEvent struct {
+	Reason        string
+	ExitCode      int
+	BytesSent     int64
+	BytesReceived int64
+}
+
+func (ConnectedEvent) event()    {}
+func (ReconnectingEvent) event() {}
+func (ResizedEvent) event()      {}
+func (TitleChangedEvent) event() {}
+func (NotificationEvent) event() {}
+func (NoticeEvent) event()       {}
+func (ClosedEvent) event()       {}
+
+// emit sends e to the client's Events channel, if there is one
+func (c *Client) emit(e Event) {
+	if c.Events != nil {
+		c.Events <- e
+	}
+}
+
+// formatNotice returns the message carrying a server notice
+func formatNotice(text string) []byte {
+	return []byte("notice:" + text)
+}
+
+// parseNotice parses a notice message, reporting false if p is not one
+func parseNotice(p []byte) (string, bool) {
+	return strings.CutPrefix(string(p), "notice:")
+}
+
+// formatNoticeLine renders a notice as a highlighted line of terminal output
+func formatNoticeLine(text string) []byte {
+	return []byte(fmt.Sprintf("\r\n\033[1;33m[linkterm] %s\033[0m\r\n", text))
+}
+
+// formatExitStatus returns the message reporting the shell's exit status
+func formatExitStatus(code int) []byte {
+	return []byte("exit:" + strconv.Itoa(code))
+}
+
+// parseExitStatus parses an exit status message, reporting false if p is not one
+func parseExitStatus(p []byte) (int, bool) {
+	rest, found := strings.CutPrefix(string(p), "exit:")
+	if !found {
+		return 0, false
+	}
+	code, err := strconv.Atoi(rest)
+	return code, err == nil && code >= 0
+}
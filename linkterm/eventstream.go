@@ -0,0 +1,136 @@
+package linkterm
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// eventBacklog is how many events a subscriber of the event stream may fall
+// behind before it is dropped
+const eventBacklog = 256
+
+// eventHub hands every recorded event to the subscribers of the event stream
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan AuditEvent]struct{}
+	closed bool
+}
+
+// subscribe returns a channel receiving every event published from now on,
+// which is closed once the subscriber is dropped or the hub closed
+func (h *eventHub) subscribe() chan AuditEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan AuditEvent, eventBacklog)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	if h.subs == nil {
+		h.subs = make(map[chan AuditEvent]struct{})
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops delivering events to ch
+func (h *eventHub) unsubscribe(ch chan AuditEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// publish delivers event to every subscriber without waiting, dropping
+// subscribers too far behind so they cannot hold up sessions. It reports how
+// many were dropped.
+func (h *eventHub) publish(event AuditEvent) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dropped := 0
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// close ends every subscription, as the server is shutting down
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+	}
+	h.subs = nil
+}
+
+// streamedEvent is an event as sent on the stream, without the audit log's
+// hash chain
+type streamedEvent struct {
+	AuditEvent
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// handleEvents streams events as newline-delimited JSON until the client
+// goes away, to admins
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected event stream request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, r.URL.Path) || !s.admin(w, r, identity) {
+		return
+	}
+
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from holding events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	flusher.Flush()
+
+	s.logger.Info().Str("clientIP", clientIP).Msg("Event stream client connected")
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				s.logger.Info().Str("clientIP", clientIP).Msg("Event stream client dropped")
+				return
+			}
+			if err := encoder.Encode(streamedEvent{AuditEvent: event}); err != nil {
+				return
+			}
+			if err := flusher.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// publish hands event to the event stream
+func (s *Server) publish(event AuditEvent) {
+	if dropped := s.events.publish(event); dropped > 0 {
+		s.logger.Warn().Int("subscribers", dropped).Msg("Dropped event stream clients that fell behind")
+	}
+}
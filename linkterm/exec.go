@@ -0,0 +1,231 @@
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ExecPath is where servers with Exec set run the command clients pass in
+// the cmd query parameter without a terminal, for stdio protocols such as
+// rsync's. Binary messages from the client are the command's input, an
+// empty one closing it. Binary messages to the client start with
+// execStdout or execStderr, naming the stream the rest came from, and an
+// exit status message follows once the command has exited.
+const ExecPath = "/exec"
+
+// Streams of a command's output
+const (
+	execStdout byte = 1
+	execStderr byte = 2
+)
+
+// handleExec runs the command a client asks for through the shell, carrying
+// its standard streams over the WebSocket connection
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	userAgent := r.UserAgent()
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.stats.countError("auth_failed")
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected command, authentication failed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ExecPath, Detail: err.Error()})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, ExecPath) {
+		return
+	}
+
+	command := r.URL.Query().Get("cmd")
+	if command == "" {
+		http.Error(w, "Missing command", http.StatusBadRequest)
+		return
+	}
+
+	header := make(http.Header)
+	if s.HostKey != nil {
+		s.signHandshake(r, header)
+	}
+	wsConn, err := s.upgrade(w, r, header)
+	if err != nil {
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		s.stats.countError("upgrade")
+		return
+	}
+	conn := newSafeConn(wsConn)
+	defer conn.Close()
+	if s.MaxMessageSize > 0 {
+		conn.SetReadLimit(s.MaxMessageSize)
+	}
+
+	s.logger.Info().Str("clientIP", clientIP).Str("command", command).Str("identity", identity).Msg("Running command")
+	s.record(AuditEvent{Event: "command_started", ClientIP: clientIP, UserAgent: userAgent, Path: ExecPath, Identity: identity, Detail: command})
+	code := s.runCommand(conn, command, s.acceptedEnv(r))
+	s.logger.Info().Str("clientIP", clientIP).Int("exitCode", code).Msg("Command exited")
+
+	if code >= 0 {
+		conn.WriteMessage(websocket.TextMessage, formatExitStatus(code))
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// runCommand runs command through the shell with its streams on conn and env
+// added to its environment, returning its exit status. The command is
+// killed if the client goes away.
+func (s *Server) runCommand(conn *safeConn, command string, env []string) int {
+	cmd := exec.Command(s.ShellPath, "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	setProcessGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return -1
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return -1
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return -1
+	}
+	if err := cmd.Start(); err != nil {
+		s.logger.Warn().Str("shell", s.ShellPath).Err(err).Msg("Failed to start command")
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{execStderr}, "linkterm: "+err.Error()+"\n"...))
+		return 127
+	}
+
+	// exited keeps a client leaving late from signaling a reused group ID
+	exited := make(chan struct{})
+	go func() {
+		defer stdin.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-exited:
+				default:
+					signalProcess(cmd.Process, syscall.SIGKILL, true)
+				}
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			if len(message) == 0 {
+				stdin.Close()
+				continue
+			}
+			stdin.Write(message)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	send := func(stream byte, r io.Reader) {
+		defer wg.Done()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf[1:])
+			if n > 0 {
+				buf[0] = stream
+				if conn.WriteMessage(websocket.BinaryMessage, buf[:n+1]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go send(execStdout, stdout)
+	go send(execStderr, stderr)
+	wg.Wait()
+	err = cmd.Wait()
+	close(exited)
+	return exitStatus(err)
+}
+
+// Exec runs command through the server's shell without a terminal, copying
+// stdin to it and its output to stdout and stderr, and returns its exit
+// status. The server must allow Exec.
+func (c *Client) Exec(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	dialer, header := c.handshake()
+	target, err := endpointURL(c.URL, ExecPath, url.Values{"cmd": {command}})
+	if err != nil {
+		return -1, err
+	}
+	ws, resp, err := dialer.DialContext(ctx, target, c.withEnv(header))
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return -1, fmt.Errorf("%w: server returned HTTP 401", ErrAuthFailed)
+		} else if resp != nil {
+			return -1, fmt.Errorf("server refused to run the command: HTTP %d", resp.StatusCode)
+		}
+		return -1, fmt.Errorf("failed to connect to terminal server: %w: %w", ErrHandshake, err)
+	}
+	if err := c.checkHostKey(target, "", resp); err != nil {
+		ws.Close()
+		return -1, err
+	}
+	conn := newSafeConn(ws)
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 && conn.WriteMessage(websocket.BinaryMessage, buf[:n]) != nil {
+				return
+			}
+			if err != nil {
+				conn.WriteMessage(websocket.BinaryMessage, nil)
+				return
+			}
+		}
+	}()
+
+	code := -1
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			break
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			return -1, fmt.Errorf("connection lost: %w", err)
+		}
+
+		switch {
+		case messageType == websocket.TextMessage:
+			if status, ok := parseExitStatus(message); ok {
+				code = status
+			}
+		case len(message) > 0 && message[0] == execStdout:
+			if _, err := stdout.Write(message[1:]); err != nil {
+				return -1, err
+			}
+		case len(message) > 0 && message[0] == execStderr:
+			stderr.Write(message[1:])
+		}
+	}
+
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	if code < 0 {
+		return -1, errors.New("command ended without an exit status")
+	}
+	return code, nil
+}
@@ -0,0 +1,66 @@
+package linkterm
+
+import (
+	"net/http"
+	"strings"
+)
+
+// FeaturesHeader lists the optional protocol features a peer supports. The
+// client sends it with the WebSocket handshake and the server answers with
+// the features it will use, so older peers on either side keep working.
+const FeaturesHeader = "X-LinkTerm-Features"
+
+// FeatureResizeAck makes the server acknowledge each resize with a text
+// message telling whether the terminal was resized
+const FeatureResizeAck = "resize-ack"
+
+// FeatureExitStatus makes the server send the shell's exit status in a text
+// message before closing a session that ended
+const FeatureExitStatus = "exit-status"
+
+// FeatureNotices makes the server send its notices to the client, such as
+// timeout warnings, in text messages for the client to show, instead of
+// writing them into the terminal output
+const FeatureNotices = "notices"
+
+// FeatureHello makes client and server exchange Hello messages before the
+// shell starts, see Hello
+const FeatureHello = "hello"
+
+// FeatureLowBandwidth makes the server thin the output for clients on slow
+// links, leaving colors out and skipping progress lines rewritten faster than
+// they can be shown. Clients only ask for it when told to.
+const FeatureLowBandwidth = "low-bandwidth"
+
+// serverFeatures are the features servers offer to clients asking for them
+var serverFeatures = []string{FeatureResizeAck, FeatureExitStatus, FeatureNotices, FeatureHello, FeatureLowBandwidth}
+
+// clientFeatures are the features clients ask servers for
+var clientFeatures = []string{FeatureResizeAck, FeatureExitStatus, FeatureNotices, FeatureHello}
+
+// features is a set of protocol feature names
+type features map[string]bool
+
+// parseFeatures reads the comma separated features listed in header
+func parseFeatures(header http.Header) features {
+	f := make(features)
+	for _, value := range header.Values(FeaturesHeader) {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				f[name] = true
+			}
+		}
+	}
+	return f
+}
+
+// agree returns the features of offered that are in supported
+func (f features) agree(supported []string) []string {
+	var agreed []string
+	for _, name := range supported {
+		if f[name] {
+			agreed = append(agreed, name)
+		}
+	}
+	return agreed
+}
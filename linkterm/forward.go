@@ -0,0 +1,505 @@
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ForwardPath is where servers with Forward set relay TCP connections to
+// the host:port named by the target query parameter
+const ForwardPath = "/forward"
+
+// forwardDialTimeout bounds how long the server tries to reach a target
+const forwardDialTimeout = 10 * time.Second
+
+// handleForward connects to the requested target and relays the binary
+// messages of the WebSocket connection to it
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	userAgent := r.UserAgent()
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.stats.countError("auth_failed")
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected forward, authentication failed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ForwardPath, Detail: err.Error()})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, ForwardPath) {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	dest, err := parseHostPort(target)
+	if err != nil {
+		http.Error(w, "Target must be host:port", http.StatusBadRequest)
+		return
+	}
+	if err := dest.resolve(r.Context()); err != nil {
+		s.stats.countError("forward_dial")
+		s.logger.Warn().Str("clientIP", clientIP).Str("target", target).Err(err).Msg("Failed to resolve forward target")
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	if !s.forwardAllowed(dest) {
+		s.stats.countError("forward_denied")
+		s.logger.Warn().Str("clientIP", clientIP).Str("target", target).Str("identity", identity).Msg("Rejected forward to a target not allowed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ForwardPath, Identity: identity, Detail: "forward to " + target + " not allowed"})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	conn, err := dest.dial()
+	if err != nil {
+		s.stats.countError("forward_dial")
+		s.logger.Warn().Str("clientIP", clientIP).Str("target", target).Err(err).Msg("Failed to reach forward target")
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	header := make(http.Header)
+	if s.HostKey != nil {
+		s.signHandshake(r, header)
+	}
+	wsConn, err := s.upgrade(w, r, header)
+	if err != nil {
+		conn.Close()
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		s.stats.countError("upgrade")
+		return
+	}
+	if s.MaxMessageSize > 0 {
+		wsConn.SetReadLimit(s.MaxMessageSize)
+	}
+
+	s.logger.Info().Str("clientIP", clientIP).Str("target", target).Str("identity", identity).Msg("Forwarding connection")
+	s.record(AuditEvent{Event: "forward_opened", ClientIP: clientIP, UserAgent: userAgent, Path: ForwardPath, Identity: identity, Detail: target})
+	splice(newWSConn(wsConn), conn)
+	s.logger.Info().Str("clientIP", clientIP).Str("target", target).Msg("Forwarded connection closed")
+}
+
+// forwardAllowed reports whether target matches ForwardAllow, which allows
+// everything when empty, and is allowed by ForwardPolicy if there is one
+func (s *Server) forwardAllowed(target hostPort) bool {
+	if s.ForwardPolicy != nil && !s.ForwardPolicy.allows(target) {
+		return false
+	}
+	return len(s.ForwardAllow) == 0 || target.allowedBy(s.ForwardAllow)
+}
+
+// ForwardPolicy decides which targets clients may forward to. Targets are
+// refused unless they match one of Allow and none of Deny, both host:port
+// patterns such as "*.internal:8080", "10.0.0.5:*" or "10.0.0.0/8:22".
+// Patterns naming addresses are also checked against the addresses host
+// names resolve to.
+type ForwardPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Allows reports whether the policy lets clients forward to target,
+// looking up the addresses of host names
+func (p *ForwardPolicy) Allows(target string) bool {
+	dest, err := parseHostPort(target)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), forwardDialTimeout)
+	defer cancel()
+	if err := dest.resolve(ctx); err != nil {
+		return false
+	}
+	return p.allows(dest)
+}
+
+// allows reports whether the policy lets clients forward to a resolved
+// target
+func (p *ForwardPolicy) allows(target hostPort) bool {
+	return !target.deniedBy(p.Deny) && target.allowedBy(p.Allow)
+}
+
+// LoadForwardPolicy reads a policy file of host:port patterns, one per line.
+// Lines starting with ! deny what they match, # starts a comment:
+//
+//	# Internal web services, but not the vault
+//	*.internal:443
+//	!vault.internal:*
+func LoadForwardPolicy(file string) (*ForwardPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy := &ForwardPolicy{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, deny := strings.CutPrefix(line, "!")
+		if _, _, err := net.SplitHostPort(pattern); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q is not a host:port pattern", file, i+1, pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q: %w", file, i+1, pattern, err)
+		}
+		if deny {
+			policy.Deny = append(policy.Deny, pattern)
+		} else {
+			policy.Allow = append(policy.Allow, pattern)
+		}
+	}
+	return policy, nil
+}
+
+// hostPort is a forward target in the form patterns are matched against:
+// the host name in lower case without a trailing dot, or the canonical
+// form of an IP address, the numeric port, and the addresses to dial
+type hostPort struct {
+	host  string
+	port  string
+	addrs []netip.Addr
+}
+
+// parseHostPort splits a host:port target and brings it into canonical
+// form. IPv4 addresses written the short, octal or hex ways resolvers
+// accept, such as 127.1, become dotted quads, IPv4-mapped IPv6 addresses
+// become IPv4, and localhost stands for the loopback addresses.
+func parseHostPort(target string) (hostPort, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return hostPort{}, err
+	}
+	number, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return hostPort{}, err
+	}
+	t := hostPort{host: canonicalHost(host), port: strconv.Itoa(number)}
+	if addr, ok := parseIP(t.host); ok {
+		t.addrs = []netip.Addr{addr}
+	} else if t.host == "localhost" || strings.HasSuffix(t.host, ".localhost") {
+		t.addrs = []netip.Addr{netip.IPv6Loopback(), netip.AddrFrom4([4]byte{127, 0, 0, 1})}
+	}
+	return t, nil
+}
+
+// canonicalHost lowercases a host name and drops its trailing dot, and
+// writes IP addresses in their canonical form
+func canonicalHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if addr, ok := parseIP(host); ok {
+		return addr.String()
+	}
+	return host
+}
+
+// parseIP parses an IP address, including the IPv4 forms inet_aton accepts,
+// with IPv4-mapped IPv6 addresses unmapped
+func parseIP(host string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap(), true
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+	var ip uint64
+	for i, part := range parts {
+		base := 10
+		if digits, ok := strings.CutPrefix(strings.ToLower(part), "0x"); ok {
+			base, part = 16, digits
+		} else if len(part) > 1 && part[0] == '0' {
+			base = 8
+		}
+		n, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		// The last part fills all the bytes left, the others one each
+		bits := 8
+		if i == len(parts)-1 {
+			bits = 8 * (4 - i)
+		}
+		if n >= 1<<bits {
+			return netip.Addr{}, false
+		}
+		ip = ip<<bits | n
+	}
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), true
+}
+
+// resolve looks up the addresses of the target's host name, unless it
+// already has them
+func (t *hostPort) resolve(ctx context.Context) error {
+	if len(t.addrs) > 0 {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", t.host)
+	if err != nil {
+		return err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	t.addrs = addrs
+	return nil
+}
+
+// dial connects to the first of the target's addresses that answers, so
+// the host is not looked up again after it was checked
+func (t hostPort) dial() (net.Conn, error) {
+	dialer := net.Dialer{Deadline: time.Now().Add(forwardDialTimeout)}
+	err := errors.New("no addresses for " + t.host)
+	for _, addr := range t.addrs {
+		var conn net.Conn
+		if conn, err = dialer.Dial("tcp", net.JoinHostPort(addr.String(), t.port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// allowedBy reports whether one of patterns matches the target's name, or
+// every one of its addresses matches one of them
+func (t hostPort) allowedBy(patterns []string) bool {
+	if matchHostPort(patterns, t.host, t.port) {
+		return true
+	}
+	for _, addr := range t.addrs {
+		if !matchHostPort(patterns, addr.String(), t.port) {
+			return false
+		}
+	}
+	return len(t.addrs) > 0
+}
+
+// deniedBy reports whether one of patterns matches the target's name or any
+// of its addresses
+func (t hostPort) deniedBy(patterns []string) bool {
+	if matchHostPort(patterns, t.host, t.port) {
+		return true
+	}
+	return slices.ContainsFunc(t.addrs, func(addr netip.Addr) bool {
+		return matchHostPort(patterns, addr.String(), t.port)
+	})
+}
+
+// matchHostPort reports whether a canonical host and port match one of
+// patterns, such as "*.internal:8080", "10.0.0.5:*" or "10.0.0.0/8:22"
+func matchHostPort(patterns []string, host, port string) bool {
+	for _, pattern := range patterns {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			continue
+		}
+		if ok, _ := path.Match(patternPort, port); !ok {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(patternHost); err == nil {
+			if addr, err := netip.ParseAddr(host); err == nil && prefix.Masked().Contains(addr.WithZone("")) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(canonicalHost(patternHost), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splice copies between a and b until either side ends, then closes both
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// wsConn is a net.Conn carried by the binary messages of a WebSocket
+// connection
+type wsConn struct {
+	ws     *websocket.Conn
+	reader io.Reader
+
+	writeMu sync.Mutex
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	return &wsConn{ws: ws}
+}
+
+// Read returns data from the current message, moving on to the next when
+// it is used up. A normal close reads as EOF.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			messageType, reader, err := c.ws.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			} else if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends p as one binary message
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close tells the peer the connection is done and closes it
+func (c *wsConn) Close() error {
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+// endpointURL returns the URL of a server endpoint, which sits next to the
+// terminal endpoint of the server URL, so a server mounted under a prefix
+// by a reverse proxy is reached under it too. An access token in the
+// server URL is kept.
+func endpointURL(server, endpoint string, query url.Values) (string, error) {
+	u, err := url.Parse(NewClient(server).URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", server, err)
+	}
+	u.Path = path.Join(path.Dir(u.Path), endpoint)
+	if token := u.Query().Get("access_token"); token != "" {
+		merged := url.Values{"access_token": {token}}
+		maps.Copy(merged, query)
+		query = merged
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// openForward opens a connection to target through server's forward
+// endpoint, dialing with dialer and checking the server's host key
+func (c *Client) openForward(ctx context.Context, dialer *websocket.Dialer, header http.Header, server, target string) (net.Conn, error) {
+	forward, err := endpointURL(server, ForwardPath, url.Values{"target": {target}})
+	if err != nil {
+		return nil, err
+	}
+	ws, resp, err := dialer.DialContext(ctx, forward, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%s refused to forward to %s: HTTP %d", server, target, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to reach %s: %w", server, err)
+	}
+	if err := c.checkHostKey(forward, "", resp); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return newWSConn(ws), nil
+}
+
+// jumpHeader returns the handshake header for jump host server. The
+// destination's credentials in header are left out, the jump host gets
+// those of its own URL instead.
+func jumpHeader(header http.Header, server string) http.Header {
+	hop := header.Clone()
+	hop.Del("Authorization")
+	hop.Del("Cookie")
+	if userinfo := NewClient(server).userinfo; userinfo != nil {
+		hop.Set("Authorization", basicAuth(userinfo))
+	}
+	return hop
+}
+
+// dialJumps connects to addr through the Jump servers in turn, the
+// connection through each carrying the WebSocket to the next. first
+// reaches the first server.
+func (c *Client) dialJumps(ctx context.Context, first *websocket.Dialer, header http.Header, addr string) (net.Conn, error) {
+	var conn net.Conn
+	for i, server := range c.Jump {
+		target := addr
+		if i+1 < len(c.Jump) {
+			u, err := url.Parse(NewClient(c.Jump[i+1]).URL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jump host %q: %w", c.Jump[i+1], err)
+			}
+			target = urlHostPort(u)
+		}
+
+		dialer := first
+		if conn != nil {
+			through := *first
+			previous := conn
+			through.Proxy = nil
+			through.NetDial = nil
+			through.NetDialContext = func(context.Context, string, string) (net.Conn, error) {
+				return previous, nil
+			}
+			dialer = &through
+		}
+
+		next, err := c.openForward(ctx, dialer, jumpHeader(header, server), server, target)
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, fmt.Errorf("jump host: %w", err)
+		}
+		c.logger.Debug().Str("jump", server).Str("target", target).Msg("Connected through jump host")
+		conn = next
+	}
+	return conn, nil
+}
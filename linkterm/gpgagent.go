@@ -0,0 +1,105 @@
+package linkterm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GPGAgentPath is where servers with GPGAgentForward set stand in for
+// gpg-agent, handing the connections of programs in the session to the
+// client's agent
+const GPGAgentPath = "/gpg-agent"
+
+// handleGPGAgent listens on gpg-agent's socket for a client forwarding its
+// agent, telling it about each connection as handleListen does
+func (s *Server) handleGPGAgent(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.stats.countError("auth_failed")
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected gpg-agent forward, authentication failed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: r.UserAgent(), Path: GPGAgentPath, Detail: err.Error()})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, GPGAgentPath) {
+		return
+	}
+
+	header := make(http.Header)
+	if s.HostKey != nil {
+		s.signHandshake(r, header)
+	}
+
+	if id := r.URL.Query().Get("accept"); id != "" {
+		s.servePending(w, r, header, id, identity)
+		return
+	}
+
+	socket, err := GPGSocket("agent-socket")
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("Failed to find gpg-agent socket")
+		http.Error(w, "Cannot find the gpg-agent socket", http.StatusInternalServerError)
+		return
+	}
+	listener, err := listenGPGAgent(socket)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", clientIP).Str("socket", socket).Err(err).Msg("Failed to listen for gpg-agent forward")
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.serveListener(w, r, header, listener, identity, GPGAgentPath)
+}
+
+// listenGPGAgent listens on gpg-agent's socket, replacing one left behind
+// by an agent no longer running
+func listenGPGAgent(socket string) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+		conn.Close()
+		return nil, errors.New("gpg-agent is already running on the server, stop it with gpgconf --kill gpg-agent")
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	return net.Listen("unix", socket)
+}
+
+// GPGSocket asks gpgconf where GnuPG keeps a socket, such as agent-socket or
+// agent-extra-socket, the restricted socket meant for forwarding
+func GPGSocket(name string) (string, error) {
+	out, err := exec.Command("gpgconf", "--list-dirs", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("gpgconf: %w", err)
+	}
+	// gpgconf percent-escapes the paths it lists
+	socket, err := url.PathUnescape(strings.TrimSpace(string(out)))
+	if err != nil || socket == "" {
+		return "", fmt.Errorf("gpgconf listed no %s", name)
+	}
+	return socket, nil
+}
+
+// forwardGPGAgent has the server stand in for gpg-agent, forwarding the
+// connections to the local agent at GPGAgent, until the listener is closed
+func (c *Client) forwardGPGAgent(listener *remoteListener) {
+	c.serveRemote(listener, func(conn net.Conn) {
+		agent, err := net.Dial("unix", c.GPGAgent)
+		if err != nil {
+			c.logger.Debug().Str("socket", c.GPGAgent).Err(err).Msg("Failed to reach local gpg-agent")
+			conn.Close()
+			return
+		}
+		splice(conn, agent)
+	})
+}
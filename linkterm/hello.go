@@ -0,0 +1,135 @@
+package linkterm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// helloTimeout bounds how long a peer waits for the other's hello
+const helloTimeout = 10 * time.Second
+
+// Hello is what client and server tell each other about themselves when they
+// agreed to FeatureHello. The client sends its hello as the first message of
+// the connection, the server answers with its own before starting the shell.
+type Hello struct {
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	// Features are all the optional features the peer supports, including
+	// those not negotiated in the handshake
+	Features []string `json:"features,omitempty"`
+
+	// Cols, Rows and Term describe the client's terminal, for the shell to
+	// start with
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+	Term string `json:"term,omitempty"`
+
+	// Tags are the client's labels for the session, see ParseTags
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Supports reports whether the peer supports the named feature
+func (h Hello) Supports(feature string) bool {
+	for _, name := range h.Features {
+		if name == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHello returns the message carrying a hello
+func formatHello(h Hello) ([]byte, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("hello:"), data...), nil
+}
+
+// parseHello parses a hello message, reporting false if p is not one
+func parseHello(p []byte) (Hello, bool) {
+	rest, found := strings.CutPrefix(string(p), "hello:")
+	if !found {
+		return Hello{}, false
+	}
+	var h Hello
+	if err := json.Unmarshal([]byte(rest), &h); err != nil {
+		return Hello{}, false
+	}
+	return h, true
+}
+
+// readHello reads the peer's hello, which must be the next message on conn
+func readHello(conn *safeConn) (Hello, error) {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	messageType, p, err := conn.ReadMessage()
+	if err != nil {
+		return Hello{}, err
+	}
+	h, ok := parseHello(p)
+	if messageType != websocket.TextMessage || !ok {
+		return Hello{}, errors.New("expected a hello message")
+	}
+	return h, nil
+}
+
+// writeHello sends h on conn
+func writeHello(conn *safeConn, h Hello) error {
+	data, err := formatHello(h)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// validTerm reports whether a client's TERM is safe to put in the shell's
+// environment, terminal names being short and made of plain characters
+func validTerm(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.+", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// hello exchanges hellos with the server on conn, telling it the terminal's
+// size and type, and returns the server's
+func (c *Client) hello(conn *safeConn) (Hello, error) {
+	h := Hello{Version: Version, Platform: Platform, Features: clientFeatures, Term: c.Term, Tags: c.Tags}
+	if h.Term == "" {
+		h.Term = os.Getenv("TERM")
+	}
+	size := c.Size
+	if size == nil {
+		size = terminalSize
+	}
+	if cols, rows, err := size(); err == nil && cols > 0 && rows > 0 {
+		if c.StatusLine && rows > 1 {
+			// The status line takes the bottom row
+			rows--
+		}
+		h.Cols, h.Rows = cols, rows
+	}
+
+	if err := writeHello(conn, h); err != nil {
+		return Hello{}, fmt.Errorf("failed to send hello: %w", err)
+	}
+	server, err := readHello(conn)
+	if err != nil {
+		return Hello{}, fmt.Errorf("%w: no hello from server: %w", ErrHandshake, err)
+	}
+	return server, nil
+}
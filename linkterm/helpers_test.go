@@ -0,0 +1,69 @@
+package linkterm_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+// openSession connects a terminal to srv with header and returns its
+// connection and session ID once the server lists it
+func openSession(t *testing.T, srv *linktermtest.Server, header http.Header) (*websocket.Conn, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := srv.Dialer().DialContext(ctx, srv.URL, header)
+	if err != nil {
+		t.Fatalf("dial terminal: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for ctx.Err() == nil {
+		if sessions := srv.Sessions(); len(sessions) > 0 {
+			return conn, sessions[len(sessions)-1].ID
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("session never listed")
+	return nil, ""
+}
+
+// request sends an HTTP request to srv in memory and returns the status
+func request(t *testing.T, srv *linktermtest.Server, method, path string, header http.Header) int {
+	t.Helper()
+	status, _ := fetch(t, srv, method, path, header)
+	return status
+}
+
+// fetch sends an HTTP request to srv in memory and returns the status and
+// body
+func fetch(t *testing.T, srv *linktermtest.Server, method, path string, header http.Header) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, "http://linkterm.test"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: srv.Listener.DialContext}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-ndjson") {
+		// Streams never end, the status is all there is to read
+		return resp.StatusCode, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp.StatusCode, body
+}
@@ -0,0 +1,374 @@
+package linkterm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxHistory bounds the commands kept per session, the oldest going first
+	maxHistory = 1000
+	// maxHistoryLine bounds a command line being typed, longer ones are cut
+	maxHistoryLine = 4096
+	// maxHeldKey bounds a key sequence held back until it completes
+	maxHeldKey = 32
+)
+
+// HistoryEntry is a command line typed into a session
+type HistoryEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	// Exit is the command's exit status, known only from shells marking
+	// commands with OSC 133 as shell integrations do
+	Exit *int `json:"exit,omitempty"`
+	// Edited is set if keys whose effect only the shell knows, such as cursor
+	// movement, history recall or completion, were used on the line, so the
+	// command that ran may differ
+	Edited bool `json:"edited,omitempty"`
+}
+
+// commandHistory gathers the command lines typed into a session from its
+// input, using its output to tell them from passwords and from input to
+// full-screen programs
+type commandHistory struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	// awaiting is set while the last entry waits for its exit status
+	awaiting bool
+	// unconfirmed is a line run before the terminal echoed any of it, as
+	// when typed in one message, added once the output after it shows it
+	unconfirmed *HistoryEntry
+	echo        []byte
+
+	line []byte
+	// edited is set once the line was edited with shell keys, echoed once
+	// the terminal printed something while it was typed, which it does not
+	// for passwords
+	edited, echoed bool
+	// pasting is set within bracketed paste, where line breaks do not
+	// run the line
+	pasting bool
+	// partial is a key sequence cut off at the end of the last input
+	partial []byte
+
+	// altScreen is whether a full-screen program is running
+	altScreen bool
+	// integrated is set once the shell marked a prompt with OSC 133, prompt
+	// while it waits for a command at one
+	integrated, prompt bool
+	marks              oscScanner
+}
+
+// input follows the line being typed in p, adding it to the history once run
+func (h *commandHistory) input(p []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data := p
+	if len(h.partial) > 0 {
+		data = append(h.partial, p...)
+		h.partial = nil
+	}
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == 0x1b:
+			n, complete := keyLength(data[i:])
+			if !complete {
+				if len(data)-i <= maxHeldKey {
+					h.partial = append([]byte(nil), data[i:]...)
+				}
+				return
+			}
+			switch seq := string(data[i : i+n]); seq {
+			case "\x1b[200~":
+				h.pasting = true
+			case "\x1b[201~":
+				h.pasting = false
+			default:
+				h.edited = true
+			}
+			i += n
+			continue
+		case (c == '\r' || c == '\n') && !h.pasting:
+			h.run()
+		case c == 0x7f || c == 0x08:
+			_, size := utf8.DecodeLastRune(h.line)
+			h.line = h.line[:len(h.line)-size]
+		case c == 0x03 || c == 0x15:
+			// Ctrl-C abandons the line, Ctrl-U erases it
+			h.line, h.edited, h.echoed = h.line[:0], false, false
+		case c == 0x17:
+			// Ctrl-W erases the last word
+			h.line = h.line[:bytes.LastIndexByte(bytes.TrimRight(h.line, " "), ' ')+1]
+		case c == 0x0c:
+			// Ctrl-L only redraws the screen
+		case c < 0x20 && c != '\r' && c != '\n':
+			h.edited = true
+		default:
+			if len(h.line) < maxHistoryLine {
+				h.line = append(h.line, c)
+			}
+		}
+		i++
+	}
+}
+
+// keyLength returns the length of the key sequence p starts with, a control
+// sequence, an SS3 sequence or an escaped character, or false if it is not
+// complete yet
+func keyLength(p []byte) (int, bool) {
+	if len(p) < 2 {
+		return 0, false
+	}
+	switch p[1] {
+	case '[':
+		for i := 2; i < len(p); i++ {
+			if p[i] >= 0x40 && p[i] <= 0x7e {
+				return i + 1, true
+			}
+		}
+		return 0, false
+	case 'O':
+		if len(p) < 3 {
+			return 0, false
+		}
+		return 3, true
+	}
+	return 2, true
+}
+
+// run adds the line typed to the history, unless it was not echoed or typed
+// to a program rather than the shell. h.mu must be held.
+func (h *commandHistory) run() {
+	command := strings.TrimSpace(strings.ToValidUTF8(string(h.line), ""))
+	atPrompt := !h.altScreen && (!h.integrated || h.prompt)
+	h.unconfirmed, h.echo = nil, nil
+	if command != "" && atPrompt {
+		entry := HistoryEntry{Time: time.Now().UTC(), Command: command, Edited: h.edited}
+		if h.echoed {
+			h.add(entry)
+		} else {
+			h.unconfirmed = &entry
+		}
+	}
+	h.line, h.edited, h.echoed = h.line[:0], false, false
+}
+
+// add adds entry to the history, dropping the oldest beyond maxHistory.
+// h.mu must be held.
+func (h *commandHistory) add(entry HistoryEntry) {
+	h.entries = append(h.entries, entry)
+	if over := len(h.entries) - maxHistory; over > 0 {
+		h.entries = slices.Delete(h.entries, 0, over)
+	}
+	h.awaiting = true
+}
+
+// confirm adds the unconfirmed line once data, the output following it,
+// echoes it, and gives up on it after output too long to be its echo. Only
+// its first word is looked for, as line editing redraws the rest in pieces.
+// h.mu must be held.
+func (h *commandHistory) confirm(data []byte) {
+	if h.unconfirmed == nil {
+		return
+	}
+	h.echo = append(h.echo, data...)
+	word, _, _ := strings.Cut(h.unconfirmed.Command, " ")
+	if bytes.Contains(h.echo, []byte(word)) {
+		h.add(*h.unconfirmed)
+	} else if len(h.echo) < 2*len(h.unconfirmed.Command)+256 {
+		return
+	}
+	h.unconfirmed, h.echo = nil, nil
+}
+
+// output follows the terminal output in data for echoes, full-screen programs
+// and the marks shells with OSC 133 integration print around commands: A and
+// B for the prompt, C once a command runs and D with its exit status
+func (h *commandHistory) output(data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.line) > 0 {
+		h.echoed = true
+	}
+	h.confirm(data)
+	for i := 0; ; {
+		start := bytes.Index(data[i:], []byte("\x1b[?"))
+		if start < 0 {
+			break
+		}
+		i += start
+		n, complete := escapeLength(data[i:])
+		if !complete {
+			break
+		}
+		if alt, ok := altScreenSwitch(data[i : i+n]); ok {
+			h.altScreen = alt
+		}
+		i += n
+	}
+
+	commands, _ := h.marks.scan(data)
+	for _, seq := range commands {
+		if seq.code != "133" {
+			continue
+		}
+		h.integrated = true
+		// A line not echoed before its command ran or ended never was
+		h.unconfirmed, h.echo = nil, nil
+		mark, params, _ := strings.Cut(seq.text, ";")
+		switch mark {
+		case "A", "B":
+			h.prompt = true
+		case "C":
+			h.prompt = false
+		case "D":
+			h.prompt = false
+			status, _, _ := strings.Cut(params, ";")
+			if code, err := strconv.Atoi(status); err == nil && h.awaiting {
+				h.entries[len(h.entries)-1].Exit = &code
+			}
+			h.awaiting = false
+		}
+	}
+}
+
+// list returns the commands run so far, oldest first
+func (h *commandHistory) list() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.entries)
+}
+
+// savedHistory is the history of a finished session as written to HistoryDir
+type savedHistory struct {
+	Session  string         `json:"session"`
+	Identity string         `json:"identity,omitempty"`
+	Commands []HistoryEntry `json:"commands"`
+}
+
+// DefaultHistoryPath returns where the history of finished sessions is kept
+// by default
+func DefaultHistoryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "linkterm", "history"), nil
+}
+
+// saveHistory writes the history of the finished session sess to HistoryDir,
+// readable by the server's user only
+func (s *Server) saveHistory(sess *session) error {
+	s.sessionsMu.Lock()
+	history, identity := sess.history, sess.identity
+	s.sessionsMu.Unlock()
+	if history == nil || s.HistoryDir == "" {
+		return nil
+	}
+
+	commands := history.list()
+	if commands == nil {
+		commands = []HistoryEntry{}
+	}
+	data, err := json.Marshal(savedHistory{Session: sess.id, Identity: identity, Commands: commands})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.HistoryDir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(s.HistoryDir, sess.id+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadHistory returns the history of session id, running or saved to
+// HistoryDir, or nil if there is none
+func (s *Server) loadHistory(id string) (*savedHistory, error) {
+	s.sessionsMu.Lock()
+	sess := s.sessions[id]
+	var history *commandHistory
+	var identity string
+	if sess != nil {
+		history, identity = sess.history, sess.identity
+	}
+	s.sessionsMu.Unlock()
+	if history != nil {
+		return &savedHistory{Session: id, Identity: identity, Commands: history.list()}, nil
+	}
+
+	// Session IDs are hex, anything else cannot name a file of ours
+	if _, err := hex.DecodeString(id); err != nil || id == "" || s.HistoryDir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.HistoryDir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved savedHistory
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s.json: %w", id, err)
+	}
+	return &saved, nil
+}
+
+// handleHistory serves the command history of a session as JSON, while it
+// runs and, with HistoryDir, after it ended. Only the identity that started
+// the session and those listed in Admins may read it, the Authorizer can
+// only refuse them.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", getClientIP(r)).Err(err).Msg("Rejected history request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if identity == "" {
+		// Without a login every session would be everyone's to read
+		s.logger.Warn().Str("clientIP", getClientIP(r)).Msg("Rejected anonymous history request")
+		http.Error(w, "History needs a login", http.StatusForbidden)
+		return
+	}
+	if !s.authorized(w, r, identity, r.URL.Path) {
+		return
+	}
+
+	saved, err := s.loadHistory(r.PathValue("id"))
+	if err != nil {
+		s.logger.Error().Str("session", r.PathValue("id")).Err(err).Msg("Failed to read session history")
+		http.Error(w, "Failed to read history", http.StatusInternalServerError)
+		return
+	}
+	if saved == nil || (saved.Identity != identity && !slices.Contains(s.Admins, identity)) {
+		// Sessions of others look like unknown ones
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	s.record(AuditEvent{Event: "history_read", Session: saved.Session, ClientIP: getClientIP(r), UserAgent: r.UserAgent(), Path: r.URL.Path, Identity: identity})
+	entries := saved.Commands
+	if entries == nil {
+		entries = []HistoryEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,132 @@
+package linkterm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/linksocks/linkterm/linkterm"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+func TestHistoryForOwnerOnly(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	server := linkterm.NewServer(0, "", "sh")
+	server.History = true
+	server.HistoryDir = dir
+	server.Admins = []string{"root"}
+	server.AddAuthenticator(userAuth)
+	// An authorizer allowing everything must not open others' history
+	server.SetAuthorizer(func(context.Context, linkterm.AuthorizationRequest) error { return nil })
+	srv := linktermtest.NewServer(server, linktermtest.NewBackend(linktermtest.Echo))
+	defer srv.Close()
+
+	conn, id := openSession(t, srv, http.Header{"X-User": {"alice"}})
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	path := "/session/" + id + "/history"
+	waitFor(t, "the command", func() bool {
+		_, body := fetch(t, srv, http.MethodGet, path, http.Header{"X-User": {"alice"}})
+		return commands(t, body) == 1
+	})
+
+	check := func(when string) {
+		t.Helper()
+		for _, tt := range []struct {
+			user string
+			want int
+		}{
+			{"alice", http.StatusOK},
+			{"root", http.StatusOK},
+			{"bob", http.StatusNotFound},
+			{"", http.StatusUnauthorized},
+		} {
+			var header http.Header
+			if tt.user != "" {
+				header = http.Header{"X-User": {tt.user}}
+			}
+			if status := request(t, srv, http.MethodGet, path, header); status != tt.want {
+				t.Errorf("%s, %q reading alice's history: got status %d, want %d", when, tt.user, status, tt.want)
+			}
+		}
+	}
+	check("while running")
+
+	conn.Close()
+	waitFor(t, "the session to end", func() bool { return len(srv.Sessions()) == 0 })
+	if _, err := os.Stat(filepath.Join(dir, id+".json")); err != nil {
+		t.Fatalf("history not saved: %v", err)
+	}
+	_, body := fetch(t, srv, http.MethodGet, path, http.Header{"X-User": {"alice"}})
+	if n := commands(t, body); n != 1 {
+		t.Errorf("saved history has %d commands, want 1", n)
+	}
+	check("after the end")
+}
+
+func TestHistoryNeedsLogin(t *testing.T) {
+	server := linkterm.NewServer(0, "", "sh")
+	server.History = true
+	srv := linktermtest.NewServer(server, linktermtest.NewBackend(linktermtest.Echo))
+	defer srv.Close()
+	_, id := openSession(t, srv, nil)
+
+	// Anonymous sessions belong to nobody, not to every anonymous caller
+	if status := request(t, srv, http.MethodGet, "/session/"+id+"/history", nil); status != http.StatusForbidden {
+		t.Errorf("anonymous history: got status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestHistoryIDsNameNoOtherFiles(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "history")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	saved := []byte(`{"session":"x","identity":"alice","commands":[{"command":"secret"}]}`)
+	for _, name := range []string{filepath.Join(root, "outside.json"), filepath.Join(dir, "notes.json")} {
+		if err := os.WriteFile(name, saved, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := linkterm.NewServer(0, "", "sh")
+	server.History = true
+	server.HistoryDir = dir
+	server.AddAuthenticator(userAuth)
+	srv := linktermtest.NewServer(server, nil)
+	defer srv.Close()
+
+	for _, id := range []string{"..%2Foutside", "notes", "%2E%2E%2Foutside", "..%5Coutside"} {
+		if status := request(t, srv, http.MethodGet, "/session/"+id+"/history", http.Header{"X-User": {"alice"}}); status != http.StatusNotFound {
+			t.Errorf("history of %s: got status %d, want %d", id, status, http.StatusNotFound)
+		}
+	}
+}
+
+// commands returns how many commands a history response lists
+func commands(t *testing.T, body []byte) int {
+	t.Helper()
+	var entries []linkterm.HistoryEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// waitFor polls done until it holds, failing after a few seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,287 @@
+package linkterm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HostConfig is what a config file sets for a server name
+type HostConfig struct {
+	// URL is the server to connect to, the name itself if not set
+	URL string
+	// Token, LinkSocksURL and Proxy are as for the client's flags, Token and
+	// Proxy accepting the same references to secrets
+	Token        string
+	LinkSocksURL string
+	Proxy        string
+	// ProxyCommand is run to reach the server, as for --proxy-command
+	ProxyCommand string
+	// Jump are the jump hosts to connect through, each a URL or a name
+	// configured in turn
+	Jump []string
+	// SendEnv are patterns of the environment variables to send
+	SendEnv []string
+	// LocalForward, RemoteForward and DynamicForward are forwardings set up
+	// by linkterm forward, as for its -L, -R and -D
+	LocalForward   []string
+	RemoteForward  []string
+	DynamicForward []string
+	// ForwardGPGAgent forwards the local gpg-agent into sessions
+	ForwardGPGAgent bool
+	// StrictHostKey refuses servers whose host key is not known
+	StrictHostKey bool
+	// ConnectTimeout bounds each connection attempt, 0 if not set
+	ConnectTimeout time.Duration
+
+	// set are the keywords given, so flags given on the command line are
+	// only overridden by settings that exist
+	set map[string]bool
+}
+
+// IsSet reports whether the config file gave keyword, in lower case
+func (h HostConfig) IsSet(keyword string) bool {
+	return h.set[keyword]
+}
+
+// Config is a client config file of Host blocks, like OpenSSH's:
+//
+//	Host prod-db
+//	    URL wss://term.example.com/terminal
+//	    LocalForward 5432:localhost:5432
+//	Host *.lab !printer.lab
+//	    Jump bastion
+//
+// A block applies to the names matching one of its patterns and none of
+// those starting with !. For a name, the first value found for a keyword is
+// used, except that SendEnv and the forwardings add up across blocks.
+type Config struct {
+	blocks []hostBlock
+}
+
+// hostBlock is a Host line and the settings under it
+type hostBlock struct {
+	patterns []string
+	settings []hostSetting
+}
+
+// hostSetting is a keyword and its value, the keyword in lower case
+type hostSetting struct {
+	keyword string
+	value   string
+}
+
+// hostKeywords are the keywords config files may use
+var hostKeywords = map[string]bool{
+	"url":             true,
+	"token":           true,
+	"linksocksurl":    true,
+	"proxy":           true,
+	"proxycommand":    true,
+	"jump":            true,
+	"sendenv":         true,
+	"localforward":    true,
+	"remoteforward":   true,
+	"dynamicforward":  true,
+	"forwardgpgagent": true,
+	"stricthostkey":   true,
+	"connecttimeout":  true,
+}
+
+// DefaultConfigPath returns where the client config file is read from by
+// default
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "linkterm", "config"), nil
+}
+
+// LoadConfig reads the config file at path. A missing file reads as empty.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := ParseConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfig reads a config file. Keywords are case-insensitive and may
+// be separated from their value by spaces or an equals sign.
+func ParseConfig(r io.Reader) (*Config, error) {
+	config := &Config{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		end := strings.IndexAny(text, " \t=")
+		if end < 0 {
+			end = len(text)
+		}
+		keyword := strings.ToLower(text[:end])
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[end:]), "="))
+		if value == "" {
+			return nil, fmt.Errorf("line %d: %s needs a value", line, keyword)
+		}
+
+		if keyword == "host" {
+			config.blocks = append(config.blocks, hostBlock{patterns: strings.Fields(value)})
+			continue
+		}
+		if !hostKeywords[keyword] {
+			return nil, fmt.Errorf("line %d: unknown keyword %q", line, keyword)
+		}
+		if len(config.blocks) == 0 {
+			return nil, fmt.Errorf("line %d: %s outside a Host block", line, keyword)
+		}
+		if err := checkHostSetting(keyword, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		block := &config.blocks[len(config.blocks)-1]
+		block.settings = append(block.settings, hostSetting{keyword: keyword, value: value})
+	}
+	return config, scanner.Err()
+}
+
+// checkHostSetting validates a value when the file is read, so mistakes are
+// reported with their line
+func checkHostSetting(keyword, value string) error {
+	var err error
+	switch keyword {
+	case "localforward", "remoteforward":
+		_, err = ParsePortForward(value)
+	case "dynamicforward":
+		_, err = ParseListenAddress(value)
+	case "forwardgpgagent", "stricthostkey":
+		_, err = parseYesNo(value)
+	case "connecttimeout":
+		_, err = time.ParseDuration(value)
+	}
+	return err
+}
+
+// parseYesNo reads a yes or no value
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected yes or no, got %q", value)
+}
+
+// Lookup returns the settings for name from the blocks matching it
+func (c *Config) Lookup(name string) HostConfig {
+	host := HostConfig{set: make(map[string]bool)}
+	for _, block := range c.blocks {
+		if !block.matches(name) {
+			continue
+		}
+		for _, setting := range block.settings {
+			host.apply(setting)
+		}
+	}
+	return host
+}
+
+// apply records a setting, keeping the first value of single valued keywords
+func (h *HostConfig) apply(setting hostSetting) {
+	value := setting.value
+	switch setting.keyword {
+	case "sendenv":
+		h.SendEnv = append(h.SendEnv, strings.Fields(value)...)
+	case "localforward":
+		h.LocalForward = append(h.LocalForward, value)
+	case "remoteforward":
+		h.RemoteForward = append(h.RemoteForward, value)
+	case "dynamicforward":
+		h.DynamicForward = append(h.DynamicForward, value)
+	}
+	if h.set[setting.keyword] {
+		return
+	}
+	h.set[setting.keyword] = true
+
+	switch setting.keyword {
+	case "url":
+		h.URL = value
+	case "token":
+		h.Token = value
+	case "linksocksurl":
+		h.LinkSocksURL = value
+	case "proxy":
+		h.Proxy = value
+	case "proxycommand":
+		h.ProxyCommand = value
+	case "jump":
+		h.Jump = strings.Split(value, ",")
+	case "forwardgpgagent":
+		h.ForwardGPGAgent, _ = parseYesNo(value)
+	case "stricthostkey":
+		h.StrictHostKey, _ = parseYesNo(value)
+	case "connecttimeout":
+		h.ConnectTimeout, _ = time.ParseDuration(value)
+	}
+}
+
+// matches reports whether the block applies to name, which must match one
+// of its patterns and none of its negated ones
+func (b hostBlock) matches(name string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok := matchWildcard(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(name))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok && !negated
+	}
+	return matched
+}
+
+// matchWildcard reports whether name matches pattern, in which * matches any
+// run of characters, slashes included, and ? any single one
+func matchWildcard(pattern, name string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if matchWildcard(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(name)
+			name = name[size:]
+		default:
+			if name == "" || pattern[0] != name[0] {
+				return false
+			}
+			name = name[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return name == ""
+}
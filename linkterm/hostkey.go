@@ -0,0 +1,231 @@
+package linkterm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Host key headers. A server with a host key signs each terminal handshake
+// with it, so clients can tell they reached the server they trusted before.
+const (
+	// HostKeyHeader carries the server's ed25519 public key
+	HostKeyHeader = "X-LinkTerm-Host-Key"
+	// HostNextKeyHeader carries the key the server will move to, vouched
+	// for by the signature
+	HostNextKeyHeader = "X-LinkTerm-Host-Next-Key"
+	// HostSignatureHeader carries the host key's signature of the handshake
+	HostSignatureHeader = "X-LinkTerm-Host-Signature"
+)
+
+// websocketGUID is appended to the client's key to form Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// LoadHostKey reads the ed25519 private key at path, stored as PEM encoded
+// PKCS #8. A missing key is generated and saved if create is set.
+func LoadHostKey(path string, create bool) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		return generateHostKey(path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: not a PEM encoded private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	hostKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return hostKey, nil
+}
+
+// generateHostKey creates a host key and saves it at path, readable only by
+// its owner
+func generateHostKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return key, file.Close()
+}
+
+// Fingerprint returns the SHA256 fingerprint of a host key, as shown to
+// users and stored in known hosts files
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// handshakeTranscript is what a host key signs. Sec-WebSocket-Accept
+// derives from the client's random key, binding the signature to one
+// handshake, along with the features agreed, the session attached to and
+// the next key announced.
+func handshakeTranscript(accept, features, session string, next ed25519.PublicKey) []byte {
+	return []byte(strings.Join([]string{
+		"linkterm host key v1",
+		accept,
+		features,
+		session,
+		base64.StdEncoding.EncodeToString(next),
+	}, "\n"))
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept answering a client's
+// Sec-WebSocket-Key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signHandshake adds the host key headers for the handshake of r to header
+func (s *Server) signHandshake(r *http.Request, header http.Header) {
+	accept := websocketAccept(r.Header.Get("Sec-WebSocket-Key"))
+	transcript := handshakeTranscript(accept, header.Get(FeaturesHeader), r.URL.Query().Get("session"), s.NextHostKey)
+
+	header.Set(HostKeyHeader, base64.StdEncoding.EncodeToString(s.HostKey.Public().(ed25519.PublicKey)))
+	if s.NextHostKey != nil {
+		header.Set(HostNextKeyHeader, base64.StdEncoding.EncodeToString(s.NextHostKey))
+	}
+	header.Set(HostSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(s.HostKey, transcript)))
+}
+
+// verifyHandshake checks the host key signature of a handshake response,
+// returning the server's key and the next key it announced. Both are nil
+// for servers without a host key.
+func verifyHandshake(resp *http.Response, session string) (key, next ed25519.PublicKey, err error) {
+	encoded := resp.Header.Get(HostKeyHeader)
+	if encoded == "" {
+		return nil, nil, nil
+	}
+	if key, err = decodeHostKey(encoded); err != nil {
+		return nil, nil, err
+	}
+	if encoded := resp.Header.Get(HostNextKeyHeader); encoded != "" {
+		if next, err = decodeHostKey(encoded); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(resp.Header.Get(HostSignatureHeader))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, nil, errors.New("malformed host key signature")
+	}
+	transcript := handshakeTranscript(resp.Header.Get("Sec-WebSocket-Accept"), resp.Header.Get(FeaturesHeader), session, next)
+	if !ed25519.Verify(key, transcript, signature) {
+		return nil, nil, errors.New("host key signature does not match the handshake")
+	}
+	return key, next, nil
+}
+
+// decodeHostKey parses a base64 encoded ed25519 public key
+func decodeHostKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed host key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// checkHostKey verifies the host key of the server at target, which signed
+// the handshake for session, against the known hosts file.
+// New servers are trusted and recorded unless StrictHostKeys is set, and a
+// next key the server announces is recorded so it is trusted once the
+// server moves to it.
+func (c *Client) checkHostKey(target, session string, resp *http.Response) error {
+	if c.KnownHostsFile == "" {
+		return nil
+	}
+	host, err := knownHostName(target)
+	if err != nil {
+		return err
+	}
+	key, next, err := verifyHandshake(resp, session)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrHostKey, host, err)
+	}
+
+	hosts, err := OpenKnownHosts(c.KnownHostsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	entries := hosts.Lookup(host)
+	if key == nil {
+		if len(entries) > 0 {
+			return fmt.Errorf("%w: %s presented no host key, but is known with %s", ErrHostKey, host, entries[0].Fingerprint)
+		}
+		return nil
+	}
+
+	fingerprint := Fingerprint(key)
+	var known *KnownHost
+	for i := range entries {
+		if entries[i].Fingerprint == fingerprint {
+			known = &entries[i]
+		}
+	}
+
+	changed := false
+	switch {
+	case known == nil && len(entries) > 0:
+		return fmt.Errorf("%w: host key of %s has changed to %s, it may be impersonated. If the change is expected, run: linkterm known-hosts remove %s",
+			ErrHostKey, host, fingerprint, host)
+	case known == nil && c.StrictHostKeys:
+		return fmt.Errorf("%w: %s is not a known host, its key is %s. Trust it with linkterm known-hosts import", ErrHostKey, host, fingerprint)
+	case known == nil:
+		c.logger.Warn().Str("host", host).Str("fingerprint", fingerprint).Msg("Trusting host key of new server")
+		hosts.Add(KnownHost{Host: host, Fingerprint: fingerprint})
+		changed = true
+	case known.Next:
+		c.logger.Info().Str("host", host).Str("fingerprint", fingerprint).Msg("Server moved to its announced host key")
+		hosts.Retire(host, fingerprint)
+		changed = true
+	}
+
+	if next != nil {
+		nextFingerprint := Fingerprint(next)
+		if hosts.Add(KnownHost{Host: host, Fingerprint: nextFingerprint, Next: true}) {
+			c.logger.Info().Str("host", host).Str("fingerprint", nextFingerprint).Msg("Server announced its next host key")
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := hosts.Save(); err != nil {
+			return fmt.Errorf("failed to save known hosts: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package linkterm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// signedResponse returns the handshake response of a server with key,
+// announcing next if it is set
+func signedResponse(t *testing.T, key ed25519.PrivateKey, next ed25519.PublicKey) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/terminal", nil)
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	header := http.Header{"Sec-Websocket-Accept": {websocketAccept(r.Header.Get("Sec-WebSocket-Key"))}}
+	s := &Server{HostKey: key, NextHostKey: next}
+	s.signHandshake(r, header)
+	return &http.Response{Header: header}
+}
+
+// newHostKey generates an ed25519 host key
+func newHostKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyPinning(t *testing.T) {
+	const target = "ws://term.example.com:8080/terminal"
+	old, next, impostor := newHostKey(t), newHostKey(t), newHostKey(t)
+	client := NewClient(target)
+	client.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	check := func(name string, resp *http.Response, ok bool) {
+		t.Helper()
+		err := client.checkHostKey(target, "", resp)
+		if ok && err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !ok && !errors.Is(err, ErrHostKey) {
+			t.Errorf("%s: got %v, want ErrHostKey", name, err)
+		}
+	}
+
+	client.StrictHostKeys = true
+	check("unknown server with strict host keys", signedResponse(t, old, nil), false)
+	client.StrictHostKeys = false
+	check("first connection", signedResponse(t, old, nil), true)
+	check("same key", signedResponse(t, old, nil), true)
+	check("changed key", signedResponse(t, impostor, nil), false)
+	check("no key", &http.Response{Header: http.Header{}}, false)
+
+	forged := signedResponse(t, impostor, nil)
+	forged.Header.Set(HostKeyHeader, signedResponse(t, old, nil).Header.Get(HostKeyHeader))
+	check("known key with a forged signature", forged, false)
+
+	// The announcement is only trusted from the current key
+	check("next key announced by another key", signedResponse(t, impostor, next.Public().(ed25519.PublicKey)), false)
+	check("next key announced", signedResponse(t, old, next.Public().(ed25519.PublicKey)), true)
+	check("moved to the next key", signedResponse(t, next, nil), true)
+	check("old key after the move", signedResponse(t, old, nil), false)
+
+	hosts, err := OpenKnownHosts(client.KnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := hosts.Lookup("term.example.com:8080")
+	if len(entries) != 1 || entries[0].Fingerprint != Fingerprint(next.Public().(ed25519.PublicKey)) || entries[0].Next {
+		t.Errorf("known hosts after the move: %+v, want only the new key", entries)
+	}
+}
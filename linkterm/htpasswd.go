@@ -0,0 +1,72 @@
+package linkterm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdDummyHash is compared against for unknown users, so they take as
+// long to turn away as wrong passwords and user names cannot be probed
+const htpasswdDummyHash = "$2a$10$rJdx5lQVsoK4CeL.2Xp3UulbvrrnaVtadKgFrDppZ9x3L8birF8Ay"
+
+// HtpasswdAuth accepts clients presenting HTTP basic credentials of a user in
+// an htpasswd file
+type HtpasswdAuth struct {
+	users map[string][]byte
+}
+
+// LoadHtpasswd reads the users of an htpasswd file, whose passwords must be
+// hashed with bcrypt, as htpasswd -B does
+func LoadHtpasswd(path string) (*HtpasswdAuth, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(entry, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: password of %s is not a bcrypt hash, create it with htpasswd -B", path, line, user)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s: no users", path)
+	}
+	return &HtpasswdAuth{users: users}, nil
+}
+
+// Authenticate implements Authenticator, the identity is "htpasswd:USER"
+func (a *HtpasswdAuth) Authenticate(r *http.Request) (string, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	hash, known := a.users[username]
+	if !known {
+		hash = []byte(htpasswdDummyHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return "", errors.New("wrong user name or password")
+	}
+	return "htpasswd:" + username, nil
+}
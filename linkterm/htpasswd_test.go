@@ -0,0 +1,77 @@
+package linkterm
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswdAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("# users\nalice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadHtpasswd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(user, password string) (string, error, time.Duration) {
+		r := httptest.NewRequest("GET", "/terminal", nil)
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		start := time.Now()
+		identity, err := auth.Authenticate(r)
+		return identity, err, time.Since(start)
+	}
+
+	if identity, err, _ := login("alice", "s3cret"); err != nil || identity != "htpasswd:alice" {
+		t.Errorf("right password: got %q, %v", identity, err)
+	}
+	if _, err, _ := login("", ""); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no credentials: got %v, want ErrNoCredentials", err)
+	}
+	_, err, wrong := login("alice", "guess")
+	if err == nil {
+		t.Error("wrong password accepted")
+	}
+	_, err, unknown := login("mallory", "s3cret")
+	if err == nil {
+		t.Error("unknown user accepted")
+	}
+	if unknown < wrong/4 {
+		t.Errorf("unknown user turned away in %s, a wrong password in %s, user names can be probed", unknown, wrong)
+	}
+}
+
+func TestHtpasswdDummyHash(t *testing.T) {
+	// Unknown users only take as long as known ones if the dummy hash costs
+	// as much as the hashes htpasswd -B writes
+	cost, err := bcrypt.Cost([]byte(htpasswdDummyHash))
+	if err != nil {
+		t.Fatalf("dummy hash is not a bcrypt hash: %v", err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash has cost %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
+
+func TestLoadHtpasswdRejectsOtherHashes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("alice:$apr1$salt$hash\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHtpasswd(file); err == nil {
+		t.Error("LoadHtpasswd accepted an MD5 hash")
+	}
+}
@@ -0,0 +1,51 @@
+package linkterm
+
+import (
+	"bytes"
+	"time"
+)
+
+// echoHideWindow is how long after sending the initial command its echo is
+// looked for, output after that is shown as is
+const echoHideWindow = 5 * time.Second
+
+// echoFilter removes the echo of a command from the output, for typing it
+// into the shell without it showing up on screen
+type echoFilter struct {
+	echo     []byte
+	held     []byte
+	deadline time.Time
+	done     bool
+}
+
+// newEchoFilter hides the echo of command appearing within echoHideWindow
+func newEchoFilter(command string) *echoFilter {
+	return &echoFilter{echo: []byte(command), deadline: time.Now().Add(echoHideWindow)}
+}
+
+// filter returns the output to show for p. What might be the start of the
+// echo is held back until the rest of it arrives.
+func (f *echoFilter) filter(p []byte) []byte {
+	if f.done {
+		return p
+	}
+	data := append(f.held, p...)
+	f.held = nil
+	if time.Now().After(f.deadline) {
+		f.done = true
+		return data
+	}
+
+	// The command may be echoed twice, by the terminal while the shell is
+	// starting and again by its line editor
+	data = bytes.ReplaceAll(data, f.echo, nil)
+
+	// Hold back the longest end of data the echo could continue from
+	for n := min(len(f.echo)-1, len(data)); n > 0; n-- {
+		if bytes.HasPrefix(f.echo, data[len(data)-n:]) {
+			f.held = append([]byte(nil), data[len(data)-n:]...)
+			return data[:len(data)-n]
+		}
+	}
+	return data
+}
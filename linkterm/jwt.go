@@ -0,0 +1,170 @@
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	// jwksMaxAge is how long fetched signing keys are trusted before a refetch
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh limits refetches for unknown keys or while the endpoint fails
+	jwksMinRefresh = time.Minute
+)
+
+// jwtAlgorithms are the signature algorithms accepted for JWTs
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWTAuth accepts clients presenting a JWT signed by a key from a JWKS
+// endpoint, e.g. issued by an identity provider or identity-aware proxy
+type JWTAuth struct {
+	JWKSURL string
+
+	// Header carries the token, such as Cf-Access-Jwt-Assertion behind
+	// Cloudflare Access. Defaults to an "Authorization: Bearer" header.
+	Header string
+
+	// Issuer and Audience must match the token's iss and aud claims if set
+	Issuer   string
+	Audience string
+
+	// Groups allowed to connect, matched against GroupsClaim (default
+	// "groups"). Any valid token may connect if empty.
+	Groups      []string
+	GroupsClaim string
+
+	// UserClaim names the claim used as identity, defaults to "sub"
+	UserClaim string
+
+	mu        sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetched   time.Time
+	attempted time.Time
+}
+
+// Authenticate implements Authenticator, the identity is "jwt:USER"
+func (a *JWTAuth) Authenticate(r *http.Request) (string, error) {
+	var raw string
+	if a.Header != "" {
+		raw = strings.TrimSpace(r.Header.Get(a.Header))
+	} else {
+		raw, _ = bearerToken(r)
+	}
+	if raw == "" {
+		return "", ErrNoCredentials
+	}
+
+	token, err := jwt.ParseSigned(raw, jwtAlgorithms)
+	if err != nil {
+		// Bearer tokens of other kinds are left to other authenticators
+		if a.Header == "" {
+			return "", ErrNoCredentials
+		}
+		return "", fmt.Errorf("invalid JWT: %w", err)
+	}
+	if len(token.Headers) == 0 {
+		return "", errors.New("JWT has no header")
+	}
+
+	keys, err := a.signingKeys(r.Context(), token.Headers[0].KeyID)
+	if err != nil {
+		return "", err
+	}
+
+	var claims jwt.Claims
+	var extra map[string]any
+	if err := token.Claims(keys, &claims, &extra); err != nil {
+		return "", fmt.Errorf("JWT signature is invalid: %w", err)
+	}
+	if claims.Expiry == nil {
+		return "", errors.New("JWT has no expiry")
+	}
+	expected := jwt.Expected{Issuer: a.Issuer, Time: time.Now()}
+	if a.Audience != "" {
+		expected.AnyAudience = jwt.Audience{a.Audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return "", fmt.Errorf("JWT rejected: %w", err)
+	}
+
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	user, _ := extra[userClaim].(string)
+	if user == "" {
+		return "", fmt.Errorf("JWT has no %s claim", userClaim)
+	}
+	identity := "jwt:" + user
+
+	if len(a.Groups) == 0 {
+		return identity, nil
+	}
+	groupsClaim := a.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	for _, group := range claimStrings(extra[groupsClaim]) {
+		if slices.Contains(a.Groups, group) {
+			return identity, nil
+		}
+	}
+	return "", fmt.Errorf("JWT user %s is not in an allowed group", user)
+}
+
+// signingKeys returns the cached key set, refetching it when it is stale or
+// lacks the key the token was signed with
+func (a *JWTAuth) signingKeys(ctx context.Context, kid string) (*jose.JSONWebKeySet, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	unknown := a.keys != nil && kid != "" && len(a.keys.Key(kid)) == 0
+	needed := a.keys == nil || time.Since(a.fetched) > jwksMaxAge || unknown
+	if needed && (a.keys == nil || time.Since(a.attempted) > jwksMinRefresh) {
+		a.attempted = time.Now()
+		var keys jose.JSONWebKeySet
+		status, err := getJSON(ctx, a.JWKSURL, "", &keys)
+		if err == nil && status != http.StatusOK {
+			err = fmt.Errorf("HTTP %d", status)
+		}
+		if err == nil {
+			a.keys = &keys
+			a.fetched = a.attempted
+		} else if a.keys == nil {
+			return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+		}
+		// Otherwise keep using the previous keys while the endpoint is down
+	}
+	return a.keys, nil
+}
+
+// claimStrings returns a claim holding a string or a list of strings as a list
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
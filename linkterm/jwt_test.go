@@ -0,0 +1,88 @@
+package linkterm_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/linksocks/linkterm/linkterm"
+)
+
+func TestJWTAuth(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stranger, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: public, KeyID: "idp", Algorithm: string(jose.EdDSA)}}})
+	}))
+	defer jwks.Close()
+
+	auth := &linkterm.JWTAuth{
+		JWKSURL:  jwks.URL,
+		Issuer:   "https://idp.example.com",
+		Audience: "linkterm",
+		Groups:   []string{"ops"},
+	}
+	token := func(key ed25519.PrivateKey, claims jwt.Claims, groups ...string) string {
+		t.Helper()
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: jose.JSONWebKey{Key: key, KeyID: "idp"}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := jwt.Signed(signer).Claims(claims).Claims(map[string]any{"groups": groups}).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	claims := func(change func(*jwt.Claims)) jwt.Claims {
+		c := jwt.Claims{
+			Subject:  "alice",
+			Issuer:   "https://idp.example.com",
+			Audience: jwt.Audience{"linkterm"},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		if change != nil {
+			change(&c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		identity string // empty if the token must be refused
+	}{
+		{"valid", token(private, claims(nil), "dev", "ops"), "jwt:alice"},
+		{"other issuer", token(private, claims(func(c *jwt.Claims) { c.Issuer = "https://evil.example.com" }), "ops"), ""},
+		{"other audience", token(private, claims(func(c *jwt.Claims) { c.Audience = jwt.Audience{"grafana"} }), "ops"), ""},
+		{"no allowed group", token(private, claims(nil), "dev"), ""},
+		{"no groups", token(private, claims(nil)), ""},
+		{"expired", token(private, claims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour)) }), "ops"), ""},
+		{"no expiry", token(private, claims(func(c *jwt.Claims) { c.Expiry = nil }), "ops"), ""},
+		{"signed by another key", token(stranger, claims(nil), "ops"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/terminal", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			identity, err := auth.Authenticate(r)
+			if tt.identity == "" && err == nil {
+				t.Errorf("accepted as %q", identity)
+			} else if tt.identity != "" && (err != nil || identity != tt.identity) {
+				t.Errorf("got %q, %v, want %q", identity, err, tt.identity)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package linkterm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KnownHost is a host key fingerprint trusted for a server
+type KnownHost struct {
+	// Host is the server's host and port
+	Host string
+	// Fingerprint is the SHA256 fingerprint of the key
+	Fingerprint string
+	// Next marks a key the server announced it will move to
+	Next bool
+}
+
+// KnownHosts is a file of the host keys trusted for servers, one per line
+// as HOST FINGERPRINT, followed by "next" for announced keys. Lines
+// starting with # are ignored and not kept when the file is saved.
+type KnownHosts struct {
+	path  string
+	hosts []KnownHost
+}
+
+// DefaultKnownHostsPath returns where known hosts are kept by default
+func DefaultKnownHostsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "linkterm", "known_hosts"), nil
+}
+
+// OpenKnownHosts reads the known hosts file at path. A missing file opens as
+// empty, created on Save.
+func OpenKnownHosts(path string) (*KnownHosts, error) {
+	hosts := &KnownHosts{path: path}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return hosts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if hosts.hosts, err = ReadKnownHosts(file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return hosts, nil
+}
+
+// ReadKnownHosts parses lines in the format of a known hosts file
+func ReadKnownHosts(r io.Reader) ([]KnownHost, error) {
+	var entries []KnownHost
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, err := parseKnownHost(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// parseKnownHost reads a line of a known hosts file
+func parseKnownHost(line string) (KnownHost, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 || len(fields) == 3 && fields[2] != "next" {
+		return KnownHost{}, errors.New("expected HOST FINGERPRINT [next]")
+	}
+	if sum, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fields[1], "SHA256:")); err != nil ||
+		!strings.HasPrefix(fields[1], "SHA256:") || len(sum) != sha256.Size {
+		return KnownHost{}, fmt.Errorf("invalid fingerprint %q", fields[1])
+	}
+	return KnownHost{Host: fields[0], Fingerprint: fields[1], Next: len(fields) == 3}, nil
+}
+
+// String formats the entry as a line of a known hosts file
+func (h KnownHost) String() string {
+	if h.Next {
+		return h.Host + " " + h.Fingerprint + " next"
+	}
+	return h.Host + " " + h.Fingerprint
+}
+
+// All returns every trusted key
+func (k *KnownHosts) All() []KnownHost {
+	return append([]KnownHost(nil), k.hosts...)
+}
+
+// Lookup returns the keys trusted for host
+func (k *KnownHosts) Lookup(host string) []KnownHost {
+	var entries []KnownHost
+	for _, entry := range k.hosts {
+		if entry.Host == host {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Add trusts a key, reporting false if it was already known for the host
+func (k *KnownHosts) Add(entry KnownHost) bool {
+	for _, known := range k.hosts {
+		if known.Host == entry.Host && known.Fingerprint == entry.Fingerprint {
+			return false
+		}
+	}
+	k.hosts = append(k.hosts, entry)
+	return true
+}
+
+// Remove stops trusting the keys of host, only the one with fingerprint if
+// it is not empty, and returns how many were removed
+func (k *KnownHosts) Remove(host, fingerprint string) int {
+	hosts := k.hosts[:0]
+	for _, entry := range k.hosts {
+		if entry.Host != host || fingerprint != "" && entry.Fingerprint != fingerprint {
+			hosts = append(hosts, entry)
+		}
+	}
+	removed := len(k.hosts) - len(hosts)
+	k.hosts = hosts
+	return removed
+}
+
+// Retire makes fingerprint the only key trusted for host, once the server
+// has moved to it
+func (k *KnownHosts) Retire(host, fingerprint string) {
+	hosts := k.hosts[:0]
+	for _, entry := range k.hosts {
+		if entry.Host != host {
+			hosts = append(hosts, entry)
+		} else if entry.Fingerprint == fingerprint {
+			entry.Next = false
+			hosts = append(hosts, entry)
+		}
+	}
+	k.hosts = hosts
+}
+
+// Save writes the known hosts back, replacing the file at once so readers
+// never see it half written
+func (k *KnownHosts) Save() error {
+	var buf bytes.Buffer
+	for _, entry := range k.hosts {
+		buf.WriteString(entry.String() + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), ".known_hosts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.path)
+}
+
+// KnownHostName returns the name a server is known by, its lowercased host
+// and port, from a URL or an address as given to the client
+func KnownHostName(server string) (string, error) {
+	return knownHostName(NewClient(server).URL)
+}
+
+// knownHostName returns the name a server URL is known by
+func knownHostName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	return strings.ToLower(urlHostPort(u)), nil
+}
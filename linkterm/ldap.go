@@ -0,0 +1,120 @@
+package linkterm
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPAuth accepts clients presenting HTTP basic credentials that bind to an
+// LDAP directory or Active Directory as a member of an allowed group
+type LDAPAuth struct {
+	// URL of the directory, ldap:// or ldaps://
+	URL string
+	// StartTLS upgrades ldap:// connections before any credentials are sent
+	StartTLS bool
+
+	// BindDN and BindPassword are the service account used to look users
+	// up, anonymous if empty
+	BindDN       string
+	BindPassword string
+
+	// BaseDN is where users are searched for
+	BaseDN string
+	// UserFilter finds a user by name, %s is replaced by the escaped name.
+	// Defaults to (uid=%s), use (sAMAccountName=%s) for Active Directory.
+	UserFilter string
+
+	// Groups the user must be a member of, as names or full DNs, checked
+	// against the user's memberOf attribute. Any user may connect if empty.
+	Groups []string
+}
+
+// Authenticate implements Authenticator, the identity is "ldap:USER"
+func (a LDAPAuth) Authenticate(r *http.Request) (string, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	// An empty password would make an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return "", errors.New("LDAP login needs a user name and password")
+	}
+
+	conn, err := ldap.DialURL(a.URL, ldap.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}))
+	if err != nil {
+		return "", fmt.Errorf("failed to reach LDAP server: %w", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(10 * time.Second)
+
+	if a.StartTLS {
+		host := strings.TrimPrefix(a.URL, "ldap://")
+		host, _, _ = strings.Cut(host, "/")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return "", fmt.Errorf("LDAP StartTLS failed: %w", err)
+		}
+	}
+
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return "", fmt.Errorf("LDAP service account bind failed: %w", err)
+		}
+	}
+
+	filter := a.UserFilter
+	if filter == "" {
+		filter = "(uid=%s)"
+	}
+	search := ldap.NewSearchRequest(a.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		strings.ReplaceAll(filter, "%s", ldap.EscapeFilter(username)), []string{"dn", "memberOf"}, nil)
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return "", fmt.Errorf("LDAP user search failed: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return "", fmt.Errorf("LDAP user %s not found", username)
+	}
+	user := result.Entries[0]
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		return "", fmt.Errorf("LDAP login of %s failed: %w", username, err)
+	}
+
+	identity := "ldap:" + username
+	if len(a.Groups) == 0 {
+		return identity, nil
+	}
+	for _, group := range user.GetAttributeValues("memberOf") {
+		if slices.ContainsFunc(a.Groups, func(allowed string) bool { return groupMatches(group, allowed) }) {
+			return identity, nil
+		}
+	}
+	return "", fmt.Errorf("LDAP user %s is not in an allowed group", username)
+}
+
+// groupMatches reports whether the group DN is allowed, either by its full
+// DN or by the value of its first RDN, e.g. the CN
+func groupMatches(groupDN, allowed string) bool {
+	dn, err := ldap.ParseDN(groupDN)
+	if err != nil {
+		return false
+	}
+	if want, err := ldap.ParseDN(allowed); err == nil && len(want.RDNs) > 0 {
+		return dn.EqualFold(want)
+	}
+	if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return false
+	}
+	return strings.EqualFold(dn.RDNs[0].Attributes[0].Value, allowed)
+}
@@ -0,0 +1,312 @@
+package linktermtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/linksocks/linkterm/linkterm"
+)
+
+// ErrKilled is the exit error of a fake process ended by a signal it did not trap
+var ErrKilled = errors.New("killed by signal")
+
+// Backend is a linkterm.Backend whose processes run a Go function instead of
+// a program, so tests can script what a client sees
+type Backend struct {
+	// Script plays the program of each started process. The process exits
+	// with its return value when it returns. Nil echoes input back until
+	// the terminal is closed.
+	Script func(p *Process) error
+
+	// StartErr, if set, is returned for every start instead of a process
+	StartErr error
+
+	mu        sync.Mutex
+	processes []*Process
+	next      int
+	started   chan struct{}
+}
+
+// NewBackend creates a fake backend running script in every process
+func NewBackend(script func(p *Process) error) *Backend {
+	return &Backend{Script: script}
+}
+
+// Start runs the backend's script for ep
+func (b *Backend) Start(ep linkterm.Endpoint) (linkterm.Process, error) {
+	if b.StartErr != nil {
+		return nil, b.StartErr
+	}
+
+	p := newProcess(ep)
+	b.mu.Lock()
+	b.processes = append(b.processes, p)
+	if b.started != nil {
+		close(b.started)
+		b.started = nil
+	}
+	b.mu.Unlock()
+
+	script := b.Script
+	if script == nil {
+		script = Echo
+	}
+	go func() {
+		err := script(p)
+		// Output written so far is still delivered, further input is refused
+		p.stdout.Close()
+		p.stdin.CloseWithError(io.ErrClosedPipe)
+		p.exit(err)
+	}()
+	return terminal{p}, nil
+}
+
+// Processes returns every process the backend has started, oldest first
+func (b *Backend) Processes() []*Process {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Process(nil), b.processes...)
+}
+
+// Next waits for the first process not yet returned by Next, which may
+// already have been started
+func (b *Backend) Next(ctx context.Context) (*Process, error) {
+	for {
+		b.mu.Lock()
+		if b.next < len(b.processes) {
+			p := b.processes[b.next]
+			b.next++
+			b.mu.Unlock()
+			return p, nil
+		}
+		if b.started == nil {
+			b.started = make(chan struct{})
+		}
+		started := b.started
+		b.mu.Unlock()
+
+		select {
+		case <-started:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Echo is the default script, it writes input back until the terminal closes
+func Echo(p *Process) error {
+	_, err := io.Copy(p.Stdout, p.Stdin)
+	if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) {
+		// The terminal went away
+		return nil
+	}
+	return err
+}
+
+// Process is a fake program started by a Backend. Its script reads what the
+// client types from Stdin and writes to the client's screen with Stdout.
+// Unlike a PTY neither direction is buffered, typing blocks until the script
+// reads it.
+type Process struct {
+	Endpoint linkterm.Endpoint
+	Stdin    io.Reader
+	Stdout   io.Writer
+
+	// stdin carries input from the server to the script, stdout output the
+	// other way
+	stdin     *io.PipeReader
+	stdinW    *io.PipeWriter
+	stdout    *io.PipeWriter
+	stdoutR   *io.PipeReader
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	cols    int
+	rows    int
+	signals []syscall.Signal
+	traps   map[syscall.Signal]chan syscall.Signal
+	// pending is input read by Expect that Stdin has not returned yet
+	pending []byte
+
+	exited  chan struct{}
+	exitErr error
+	once    sync.Once
+}
+
+// newProcess creates the pipes of a fake process for ep
+func newProcess(ep linkterm.Endpoint) *Process {
+	p := &Process{Endpoint: ep, exited: make(chan struct{})}
+	p.stdin, p.stdinW = io.Pipe()
+	p.stdoutR, p.stdout = io.Pipe()
+	p.Stdin = input{p}
+	p.Stdout = p.stdout
+	return p
+}
+
+// Expect reads input until text has been typed, leaving what follows it to
+// be read from Stdin. It fails if the terminal closes first.
+func (p *Process) Expect(text string) error {
+	buf := make([]byte, 1024)
+	for {
+		p.mu.Lock()
+		i := bytes.Index(p.pending, []byte(text))
+		if i >= 0 {
+			p.pending = p.pending[i+len(text):]
+		}
+		p.mu.Unlock()
+		if i >= 0 {
+			return nil
+		}
+
+		n, err := p.stdin.Read(buf)
+		p.mu.Lock()
+		p.pending = append(p.pending, buf[:n]...)
+		p.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("expecting %q: %w", text, err)
+		}
+	}
+}
+
+// Printf writes formatted output to the client's screen
+func (p *Process) Printf(format string, args ...any) error {
+	_, err := fmt.Fprintf(p.Stdout, format, args...)
+	return err
+}
+
+// Size returns the latest terminal size the client requested, 0 before any
+func (p *Process) Size() (cols, rows int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cols, p.rows
+}
+
+// Signals returns every signal sent to the process, in order
+func (p *Process) Signals() []syscall.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]syscall.Signal(nil), p.signals...)
+}
+
+// Trap delivers sigs to the returned channel instead of killing the process.
+// SIGKILL cannot be trapped.
+func (p *Process) Trap(sigs ...syscall.Signal) <-chan syscall.Signal {
+	ch := make(chan syscall.Signal, 16)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.traps == nil {
+		p.traps = make(map[syscall.Signal]chan syscall.Signal)
+	}
+	for _, sig := range sigs {
+		if sig != syscall.SIGKILL {
+			p.traps[sig] = ch
+		}
+	}
+	return ch
+}
+
+// Done is closed once the process has exited
+func (p *Process) Done() <-chan struct{} {
+	return p.exited
+}
+
+// Err returns the process's exit error once Done is closed
+func (p *Process) Err() error {
+	<-p.exited
+	return p.exitErr
+}
+
+// exit records the process's exit error, only the first one counts
+func (p *Process) exit(err error) {
+	p.once.Do(func() {
+		p.exitErr = err
+		close(p.exited)
+	})
+}
+
+// signal handles a signal sent by the server
+func (p *Process) signal(sig syscall.Signal) {
+	p.mu.Lock()
+	p.signals = append(p.signals, sig)
+	trap := p.traps[sig]
+	p.mu.Unlock()
+
+	if trap != nil {
+		select {
+		case trap <- sig:
+		default:
+		}
+		return
+	}
+	// Like a real program, die without waiting for the script
+	p.stdout.Close()
+	p.stdin.CloseWithError(io.ErrClosedPipe)
+	p.exit(fmt.Errorf("%w: %s", ErrKilled, sig))
+}
+
+// input reads a process's input, starting with what Expect left over
+type input struct {
+	p *Process
+}
+
+// Read returns pending input first, then reads from the terminal
+func (in input) Read(b []byte) (int, error) {
+	in.p.mu.Lock()
+	if len(in.p.pending) > 0 {
+		n := copy(b, in.p.pending)
+		in.p.pending = in.p.pending[n:]
+		in.p.mu.Unlock()
+		return n, nil
+	}
+	in.p.mu.Unlock()
+	return in.p.stdin.Read(b)
+}
+
+// terminal is the server's side of a fake process
+type terminal struct {
+	p *Process
+}
+
+// Read returns output the script wrote
+func (t terminal) Read(b []byte) (int, error) {
+	return t.p.stdoutR.Read(b)
+}
+
+// Write sends input to the script
+func (t terminal) Write(b []byte) (int, error) {
+	return t.p.stdinW.Write(b)
+}
+
+// Close closes the terminal, the script sees the end of its input
+func (t terminal) Close() error {
+	t.p.closeOnce.Do(func() {
+		t.p.stdinW.Close()
+		t.p.stdoutR.CloseWithError(os.ErrClosed)
+	})
+	return nil
+}
+
+// Resize records the terminal size
+func (t terminal) Resize(cols, rows int) error {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.p.cols, t.p.rows = cols, rows
+	return nil
+}
+
+// Signal delivers sig to the script, group makes no difference
+func (t terminal) Signal(sig syscall.Signal, group bool) error {
+	t.p.signal(sig)
+	return nil
+}
+
+// Wait blocks until the script has returned or the process was killed
+func (t terminal) Wait() error {
+	return t.p.Err()
+}
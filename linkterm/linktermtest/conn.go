@@ -0,0 +1,224 @@
+package linktermtest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipeSize is how much a connection buffers in each direction before writes
+// block, as a socket buffer would
+const pipeSize = 64 << 10
+
+// newConnPair returns the two ends of an in-memory connection. Unlike
+// net.Pipe, writes return once buffered instead of waiting for the peer to
+// read, as WebSocket peers write to each other at the same time.
+func newConnPair() (net.Conn, net.Conn) {
+	a, b := newPipe(), newPipe()
+	return &conn{in: a, out: b, readDeadline: newDeadline(), writeDeadline: newDeadline()},
+		&conn{in: b, out: a, readDeadline: newDeadline(), writeDeadline: newDeadline()}
+}
+
+// pipe carries the data of one direction of a connection
+type pipe struct {
+	mu  sync.Mutex
+	buf []byte
+	// closed is set once either end has closed the connection
+	closed bool
+	// changed is closed and replaced whenever the pipe changes
+	changed chan struct{}
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// notify wakes everyone waiting for the pipe to change, p.mu must be held
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// close stops the pipe, buffered data can still be read
+func (p *pipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.notify()
+	}
+}
+
+// read takes buffered data, waiting for some until the deadline
+func (p *pipe) read(b []byte, d *deadline) (int, error) {
+	for {
+		p.mu.Lock()
+		if len(p.buf) > 0 {
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			p.notify()
+			p.mu.Unlock()
+			return n, nil
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		if err := d.wait(changed); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// write buffers b, waiting for room until the deadline
+func (p *pipe) write(b []byte, d *deadline) (int, error) {
+	written := 0
+	for written < len(b) {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if room := pipeSize - len(p.buf); room > 0 {
+			n := min(room, len(b)-written)
+			p.buf = append(p.buf, b[written:written+n]...)
+			written += n
+			p.notify()
+			p.mu.Unlock()
+			continue
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		if err := d.wait(changed); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// deadline is a settable point in time after which waiting fails
+type deadline struct {
+	mu sync.Mutex
+	t  time.Time
+	// changed is closed and replaced whenever the deadline is set
+	changed chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{changed: make(chan struct{})}
+}
+
+// set moves the deadline, waking waiters so they observe it
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// wait blocks until ch is closed or the deadline passes
+func (d *deadline) wait(ch <-chan struct{}) error {
+	for {
+		d.mu.Lock()
+		t, changed := d.t, d.changed
+		d.mu.Unlock()
+
+		if t.IsZero() {
+			select {
+			case <-ch:
+				return nil
+			case <-changed:
+				continue
+			}
+		}
+
+		remaining := time.Until(t)
+		if remaining <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ch:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+			return os.ErrDeadlineExceeded
+		case <-changed:
+			timer.Stop()
+		}
+	}
+}
+
+// conn is one end of an in-memory connection
+type conn struct {
+	in, out       *pipe
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	closeMu sync.Mutex
+	closed  bool
+}
+
+func (c *conn) isClosed() bool {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	return c.closed
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.in.read(b, c.readDeadline)
+	if err != nil && c.isClosed() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.out.write(b, c.writeDeadline)
+	if err != nil && c.isClosed() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+// Close ends both directions, the peer reads what was buffered and then EOF
+func (c *conn) Close() error {
+	c.closeMu.Lock()
+	c.closed = true
+	c.closeMu.Unlock()
+	c.in.close()
+	c.out.close()
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *conn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
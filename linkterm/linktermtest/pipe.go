@@ -0,0 +1,71 @@
+// Package linktermtest provides an in-memory transport and a scriptable fake
+// backend, for testing code built on linkterm's Client and Server without
+// real shells or sockets
+package linktermtest
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Listener is a net.Listener whose connections are in memory, created by
+// dialing it with DialContext
+type Listener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener creates an in-memory listener
+func NewListener() *Listener {
+	return &Listener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection dialed to the listener
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener, connections already accepted stay open
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr returns the listener's address, which is the same for every listener
+func (l *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// DialContext connects to the listener, ignoring network and addr. It fits
+// websocket.Dialer's NetDialContext.
+func (l *Listener) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	server, client := newConnPair()
+	var err error
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		err = &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr{}, Err: net.ErrClosed}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	server.Close()
+	client.Close()
+	return nil, err
+}
+
+// pipeAddr is the address of in-memory connections
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
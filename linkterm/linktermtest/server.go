@@ -0,0 +1,72 @@
+package linktermtest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/linksocks/linkterm/linkterm"
+)
+
+// Server is a linkterm.Server serving on an in-memory Listener
+type Server struct {
+	*linkterm.Server
+	Listener *Listener
+
+	// URL is the terminal endpoint, reachable through Dialer
+	URL string
+
+	done chan error
+}
+
+// NewServer starts srv on a new in-memory listener, with backend starting its
+// sessions if not nil. Call Close to stop it.
+func NewServer(srv *linkterm.Server, backend linkterm.Backend) *Server {
+	if backend != nil {
+		srv.Backend = backend
+	}
+	path := srv.Path
+	if path == "" {
+		path = srv.Prefix + linkterm.DefaultPath
+	}
+
+	s := &Server{
+		Server:   srv,
+		Listener: NewListener(),
+		URL:      "ws://linkterm.test" + path,
+		done:     make(chan error, 1),
+	}
+	go func() {
+		s.done <- srv.Serve(s.Listener)
+	}()
+	return s
+}
+
+// Dialer returns a WebSocket dialer connecting to the server in memory
+func (s *Server) Dialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		NetDialContext:   s.Listener.DialContext,
+		HandshakeTimeout: 5 * time.Second,
+	}
+}
+
+// Client returns a client connecting to the server's terminal endpoint in memory
+func (s *Server) Client() *linkterm.Client {
+	client := linkterm.NewClient(s.URL)
+	client.SetCustomDialer(s.Dialer())
+	return client
+}
+
+// Close stops the server. Sessions still running are not ended.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	s.Listener.Close()
+	if serveErr := <-s.done; serveErr != nil && !errors.Is(serveErr, net.ErrClosed) {
+		return serveErr
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package linkterm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// loadTestTail is how much output is kept while looking for a command's
+// completion marker, enough for a marker split across messages
+const loadTestTail = 256
+
+// LoadTestOptions configures a load test against a terminal server
+type LoadTestOptions struct {
+	// Sessions is how many terminal sessions run at the same time
+	Sessions int
+
+	// Commands are typed into each session in turn, Iterations times. The
+	// server's shell must be POSIX compatible, as completion is detected with
+	// printf.
+	Commands   []string
+	Iterations int
+
+	// Ramp spreads the session starts over this long
+	Ramp time.Duration
+
+	// CommandTimeout fails a command whose output does not complete in time
+	CommandTimeout time.Duration
+}
+
+// LoadTestResult holds the measurements of a load test
+type LoadTestResult struct {
+	Sessions       int
+	FailedSessions int
+	Commands       int
+	FailedCommands int
+
+	// ConnectLatencies and CommandLatencies are sorted
+	ConnectLatencies []time.Duration
+	CommandLatencies []time.Duration
+
+	// Failures counts failures by kind, e.g. "connect" or "timeout"
+	Failures map[string]int
+	Elapsed  time.Duration
+}
+
+// loadTestSession collects what one session of a load test measured
+type loadTestSession struct {
+	connect  time.Duration
+	commands []time.Duration
+	failed   int
+	failure  string
+}
+
+// LoadTest opens opts.Sessions concurrent sessions to the client's URL and
+// runs the commands in each, measuring connection and command latency
+func (c *Client) LoadTest(ctx context.Context, opts LoadTestOptions) LoadTestResult {
+	sessions := max(opts.Sessions, 1)
+	results := make([]loadTestSession, sessions)
+	if opts.CommandTimeout <= 0 {
+		opts.CommandTimeout = 30 * time.Second
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if opts.Ramp > 0 {
+				select {
+				case <-time.After(opts.Ramp * time.Duration(i) / time.Duration(sessions)):
+				case <-ctx.Done():
+					results[i].failure = "cancelled"
+					return
+				}
+			}
+			results[i] = c.loadTestSession(ctx, opts)
+		}()
+	}
+	wg.Wait()
+
+	result := LoadTestResult{Sessions: sessions, Failures: map[string]int{}, Elapsed: time.Since(start)}
+	for _, sess := range results {
+		if sess.connect > 0 {
+			result.ConnectLatencies = append(result.ConnectLatencies, sess.connect)
+		}
+		result.CommandLatencies = append(result.CommandLatencies, sess.commands...)
+		result.Commands += len(sess.commands) + sess.failed
+		result.FailedCommands += sess.failed
+		if sess.failure != "" {
+			result.FailedSessions++
+			result.Failures[sess.failure]++
+		}
+	}
+	sort.Slice(result.ConnectLatencies, func(i, j int) bool { return result.ConnectLatencies[i] < result.ConnectLatencies[j] })
+	sort.Slice(result.CommandLatencies, func(i, j int) bool { return result.CommandLatencies[i] < result.CommandLatencies[j] })
+	return result
+}
+
+// loadTestSession runs one session of a load test
+func (c *Client) loadTestSession(ctx context.Context, opts LoadTestOptions) loadTestSession {
+	var result loadTestSession
+
+	start := time.Now()
+	conn, _, err := c.open(c.URL)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAuthFailed):
+			result.failure = "auth"
+		case errors.Is(err, ErrProxy):
+			result.failure = "proxy"
+		default:
+			result.failure = "connect"
+		}
+		c.logger.Debug().Err(err).Msg("Load test session failed to connect")
+		return result
+	}
+	result.connect = time.Since(start)
+	defer conn.Close()
+	if c.MaxMessageSize > 0 {
+		conn.SetReadLimit(c.MaxMessageSize)
+	}
+
+	output := make(chan []byte, 64)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(output)
+		for {
+			messageType, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			select {
+			case output <- p:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	marker := 0
+	var tail []byte
+	for iteration := 0; iteration < max(opts.Iterations, 1); iteration++ {
+		for _, command := range opts.Commands {
+			marker++
+			// The typed command echoes with %s in it, so only printf's output matches
+			want := []byte(fmt.Sprintf("__LT_%d__", marker))
+			line := fmt.Sprintf("%s; printf '__LT_%%s__\\n' %d\r", command, marker)
+
+			begin := time.Now()
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+				result.failed++
+				result.failure = "disconnected"
+				return result
+			}
+
+			timeout := time.NewTimer(opts.CommandTimeout)
+			done := false
+			for !done {
+				select {
+				case p, ok := <-output:
+					if !ok {
+						timeout.Stop()
+						result.failed++
+						result.failure = "disconnected"
+						return result
+					}
+					tail = append(tail, p...)
+					if bytes.Contains(tail, want) {
+						result.commands = append(result.commands, time.Since(begin))
+						tail = tail[:0]
+						done = true
+					} else if over := len(tail) - loadTestTail; over > 0 {
+						tail = tail[over:]
+					}
+				case <-timeout.C:
+					result.failed++
+					result.failure = "timeout"
+					return result
+				case <-ctx.Done():
+					timeout.Stop()
+					result.failure = "cancelled"
+					return result
+				}
+			}
+			timeout.Stop()
+		}
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return result
+}
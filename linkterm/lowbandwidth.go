@@ -0,0 +1,234 @@
+package linkterm
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// lowBandwidthWindow is how long output for low bandwidth clients gathers
+// before it is thinned and sent, which bounds the delay it adds to echoes
+const lowBandwidthWindow = 100 * time.Millisecond
+
+const (
+	// maxHeldCSI bounds a control sequence held back until it completes,
+	// longer ones are not control sequences worth waiting for
+	maxHeldCSI = 256
+	// maxHeldOSC bounds an operating system command held back, such as a
+	// title or hyperlink, until its terminator arrives
+	maxHeldOSC = 4096
+)
+
+// outputThinner reduces terminal output for clients on slow links: colors are
+// left out and progress lines rewritten within one batch of output are only
+// sent as last written
+type outputThinner struct {
+	// cols is the terminal width, lines as wide may have wrapped
+	cols int
+
+	out []byte
+	// prev and cur are where the line's last and current segments start in
+	// out, segments being separated by carriage returns, prev being -1 when
+	// the line has no earlier segment that may be dropped
+	prev, cur int
+	// prevWidth is the most columns the previous segment may cover,
+	// curNarrow and curWide the least and most the current one may, wide
+	// characters taking one or two. prevPlain and curPlain tell whether they
+	// hold nothing but text and colors.
+	prevWidth           int
+	curNarrow, curWide  int
+	prevPlain, curPlain bool
+}
+
+// thinOutput thins data for a terminal cols wide, returning what to send and
+// an incomplete control sequence at its end to hold back for the next batch
+func thinOutput(data []byte, cols int) (out, rest []byte) {
+	if cols <= 0 {
+		cols = 80
+	}
+	t := &outputThinner{cols: cols, out: make([]byte, 0, len(data)), prev: -1, curPlain: true}
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == 0x1b:
+			n, complete := escapeLength(data[i:])
+			if !complete {
+				return t.out, data[i:]
+			}
+			t.escape(data[i : i+n])
+			i += n
+		case c == '\r' && (i+1 == len(data) || data[i+1] != '\n'):
+			t.carriageReturn()
+			i++
+		case c == '\n':
+			t.out = append(t.out, c)
+			t.newLine()
+			i++
+		case c < 0x20 || c == 0x7f:
+			t.out = append(t.out, c)
+			t.curPlain = false
+			i++
+		default:
+			r, n := utf8.DecodeRune(data[i:])
+			t.out = append(t.out, data[i:i+n]...)
+			t.curNarrow++
+			t.curWide++
+			if r >= utf8.RuneSelf {
+				t.curWide++
+			}
+			t.supersede()
+			i += n
+		}
+	}
+	return t.out, nil
+}
+
+// escape adds an escape sequence to the output, leaving colors out
+func (t *outputThinner) escape(seq []byte) {
+	if len(seq) > 2 && seq[1] == '[' {
+		params, final := string(seq[2:len(seq)-1]), seq[len(seq)-1]
+		switch {
+		case final == 'm' && (params == "" || strings.Trim(params, "0123456789;:") == ""):
+			if params != "" {
+				if params = withoutColors(params); params == "" {
+					return
+				}
+				seq = []byte("\x1b[" + params + "m")
+			}
+			t.out = append(t.out, seq...)
+			return
+		case final == 'K' && (params == "" || params == "0" || params == "2") && t.cur == len(t.out):
+			// Erasing the line before writing the segment hides what was there
+			t.out = append(t.out, seq...)
+			if t.prev >= 0 && t.prevPlain {
+				t.prevWidth = 0
+				t.supersede()
+			}
+			return
+		}
+	}
+	t.out = append(t.out, seq...)
+	t.curPlain = false
+}
+
+// carriageReturn starts a new segment of the line, written over the current
+// one
+func (t *outputThinner) carriageReturn() {
+	t.out = append(t.out, '\r')
+	t.prev, t.prevWidth, t.prevPlain = t.cur, t.curWide, t.curPlain
+	if t.prevWidth >= t.cols {
+		// It may have wrapped, the carriage return only goes back one row
+		t.prevPlain = false
+	}
+	t.cur, t.curNarrow, t.curWide, t.curPlain = len(t.out), 0, 0, true
+}
+
+// newLine forgets the segments of the line just ended
+func (t *outputThinner) newLine() {
+	t.prev, t.prevWidth, t.prevPlain = -1, 0, false
+	t.cur, t.curNarrow, t.curWide, t.curPlain = len(t.out), 0, 0, true
+}
+
+// supersede drops the text of the previous segment of the line once the
+// current one, written over it, covers all of it. Its attribute changes move
+// to the current one, as the text after them depends on them.
+func (t *outputThinner) supersede() {
+	if t.prev < 0 || !t.prevPlain || !t.curPlain || t.curNarrow < t.prevWidth {
+		return
+	}
+	// One carriage return is enough where there were two
+	start := t.prev
+	if start > 0 && t.out[start-1] == '\r' {
+		start--
+	}
+	cur := append(attributes(t.out[t.prev:t.cur-1]), t.out[t.cur:]...)
+	t.out = append(append(t.out[:start], '\r'), cur...)
+	t.cur, t.prev = start+1, -1
+}
+
+// attributes returns the graphic rendition sequences of a plain segment that
+// still matter after it, those after its last reset
+func attributes(segment []byte) []byte {
+	var attrs []byte
+	for i := 0; i < len(segment); {
+		if segment[i] != 0x1b {
+			i++
+			continue
+		}
+		n, _ := escapeLength(segment[i:])
+		seq := segment[i : i+n]
+		if params := string(seq[2 : len(seq)-1]); params == "" || params == "0" {
+			attrs = attrs[:0]
+		}
+		attrs = append(attrs, seq...)
+		i += n
+	}
+	return attrs
+}
+
+// escapeLength returns the length of the escape sequence p starts with, or
+// false if it is not complete yet
+func escapeLength(p []byte) (int, bool) {
+	if len(p) < 2 {
+		return 0, false
+	}
+	switch p[1] {
+	case '[':
+		for i := 2; i < len(p); i++ {
+			if p[i] >= 0x40 && p[i] <= 0x7e {
+				return i + 1, true
+			}
+			if p[i] < 0x20 || i >= maxHeldCSI {
+				// Not a control sequence, pass the escape on alone
+				return 1, true
+			}
+		}
+		return 0, false
+	case ']', 'P', '_', '^':
+		// Strings end with BEL or ST
+		if i := bytes.IndexAny(p[2:], "\a\x1b"); i >= 0 {
+			end := i + 2
+			if p[end] == '\a' {
+				return end + 1, true
+			}
+			if end+1 < len(p) {
+				return end + 2, true
+			}
+		}
+		if len(p) >= maxHeldOSC {
+			return 1, true
+		}
+		return 0, false
+	}
+	return 2, true
+}
+
+// withoutColors returns the parameters of a select graphic rendition
+// sequence without those setting colors, keeping attributes such as bold
+func withoutColors(params string) string {
+	var kept []string
+	fields := strings.Split(params, ";")
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		code, _, sub := strings.Cut(field, ":")
+		n, _ := strconv.Atoi(code)
+		switch {
+		case n == 38 || n == 48 || n == 58:
+			if !sub && i+1 < len(fields) {
+				// 5;INDEX or 2;R;G;B follow as separate parameters
+				switch fields[i+1] {
+				case "5":
+					i += 2
+				case "2":
+					i += 4
+				}
+			}
+		case n >= 30 && n <= 37, n == 39, n >= 40 && n <= 47, n == 49, n == 59,
+			n >= 90 && n <= 97, n >= 100 && n <= 107:
+		default:
+			kept = append(kept, field)
+		}
+	}
+	return strings.Join(kept, ";")
+}
@@ -0,0 +1,46 @@
+package linkterm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// notifyInterval is the least time between desktop notifications, so a
+	// runaway program cannot flood the desktop
+	notifyInterval = time.Second
+	// notifyTimeout bounds how long the notification tool may run
+	notifyTimeout = 10 * time.Second
+)
+
+// desktopNotifier raises desktop notifications with the platform's tool,
+// dropping those that come too fast
+type desktopNotifier struct {
+	logger zerolog.Logger
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// notify shows a notification without waiting for it
+func (n *desktopNotifier) notify(title, body string) {
+	n.mu.Lock()
+	if time.Since(n.last) < notifyInterval {
+		n.mu.Unlock()
+		n.logger.Debug().Str("title", title).Msg("Dropped desktop notification, too many at once")
+		return
+	}
+	n.last = time.Now()
+	n.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		if err := notifyCommand(ctx, title, body).Run(); err != nil {
+			n.logger.Debug().Err(err).Msg("Could not show desktop notification")
+		}
+	}()
+}
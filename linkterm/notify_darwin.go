@@ -0,0 +1,18 @@
+//go:build darwin
+
+package linkterm
+
+import (
+	"context"
+	"os/exec"
+)
+
+// notifyCommand shows a notification with AppleScript, passing the text as
+// arguments so it needs no quoting
+func notifyCommand(ctx context.Context, title, body string) *exec.Cmd {
+	return exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, body)
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !windows
+
+package linkterm
+
+import (
+	"context"
+	"os/exec"
+)
+
+// notifyCommand shows a notification with notify-send from libnotify
+func notifyCommand(ctx context.Context, title, body string) *exec.Cmd {
+	return exec.CommandContext(ctx, "notify-send", "--app-name=linkterm", "--", title, body)
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package linkterm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast notification with the text passed in the
+// environment, so it needs no quoting
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:LINKTERM_NOTIFY_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:LINKTERM_NOTIFY_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('linkterm').Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+// notifyCommand shows a toast notification with PowerShell
+func notifyCommand(ctx context.Context, title, body string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "LINKTERM_NOTIFY_TITLE="+title, "LINKTERM_NOTIFY_BODY="+body)
+	return cmd
+}
@@ -0,0 +1,42 @@
+package linkterm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PipeScheme prefixes named pipe addresses given to listen on, as in
+// npipe:////./pipe/linkterm
+const PipeScheme = "npipe://"
+
+// DefaultPipeSecurity lets only administrators and the system account open
+// the server's named pipe
+const DefaultPipeSecurity = "D:P(A;;GA;;;BA)(A;;GA;;;SY)"
+
+// ParsePipeAddress turns npipe:////./pipe/NAME into the Windows pipe path
+// \\.\pipe\NAME
+func ParsePipeAddress(addr string) (string, error) {
+	path, found := strings.CutPrefix(addr, PipeScheme)
+	if !found {
+		return "", fmt.Errorf("invalid pipe address %q, expected %s//./pipe/NAME", addr, PipeScheme)
+	}
+	path = strings.ReplaceAll(path, "/", `\`)
+	if !strings.HasPrefix(path, `\\.\pipe\`) || len(path) == len(`\\.\pipe\`) {
+		return "", fmt.Errorf("invalid pipe address %q, expected %s//./pipe/NAME", addr, PipeScheme)
+	}
+	return path, nil
+}
+
+// StartPipe is like Start but serves on the named pipe at path, such as
+// \\.\pipe\linkterm, for local integrations on Windows. securityDescriptor
+// is the SDDL controlling who may open the pipe, DefaultPipeSecurity if empty.
+func (s *Server) StartPipe(path, securityDescriptor string) error {
+	if securityDescriptor == "" {
+		securityDescriptor = DefaultPipeSecurity
+	}
+	listener, err := listenPipe(path, securityDescriptor)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
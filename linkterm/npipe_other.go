@@ -0,0 +1,13 @@
+//go:build !windows
+
+package linkterm
+
+import (
+	"errors"
+	"net"
+)
+
+// listenPipe fails outside of Windows, which has no named pipes
+func listenPipe(path, securityDescriptor string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only available on Windows")
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package linkterm
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// listenPipe listens on the named pipe at path, open to those
+// securityDescriptor allows
+func listenPipe(path, securityDescriptor string) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{SecurityDescriptor: securityDescriptor})
+}
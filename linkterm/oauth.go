@@ -0,0 +1,280 @@
+package linkterm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// oauthProvider describes the OAuth device flow of an identity provider
+type oauthProvider struct {
+	codeURL  string
+	tokenURL string
+	scope    string
+	// tokenField is the token response field sent to the server
+	tokenField string
+}
+
+var oauthProviders = map[string]oauthProvider{
+	"github": {
+		codeURL:    "https://github.com/login/device/code",
+		tokenURL:   "https://github.com/login/oauth/access_token",
+		scope:      "read:user read:org",
+		tokenField: "access_token",
+	},
+	"google": {
+		codeURL:    "https://oauth2.googleapis.com/device/code",
+		tokenURL:   "https://oauth2.googleapis.com/token",
+		scope:      "openid email",
+		tokenField: "id_token",
+	},
+}
+
+// DeviceLogin signs a user in with an OAuth device flow: the user opens a
+// link on any device and enters a code, while the client polls for the token
+type DeviceLogin struct {
+	// Provider is "github" or "google"
+	Provider     string
+	ClientID     string
+	ClientSecret string
+
+	// Prompt shows the user where to go and which code to enter
+	Prompt func(verificationURL, userCode string)
+}
+
+// Token runs the device flow and returns the token the server validates,
+// a GitHub access token or a Google ID token
+func (d DeviceLogin) Token(ctx context.Context) (string, error) {
+	provider, ok := oauthProviders[d.Provider]
+	if !ok {
+		return "", fmt.Errorf("unknown login provider %q, use github or google", d.Provider)
+	}
+	if d.ClientID == "" {
+		return "", errors.New("login requires an OAuth client ID")
+	}
+
+	var code struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		// Google calls it verification_url
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	form := url.Values{"client_id": {d.ClientID}, "scope": {provider.scope}}
+	if err := postForm(ctx, provider.codeURL, form, &code); err != nil {
+		return "", fmt.Errorf("failed to start login: %w", err)
+	}
+
+	verification := code.VerificationURI
+	if verification == "" {
+		verification = code.VerificationURL
+	}
+	if d.Prompt != nil {
+		d.Prompt(verification, code.UserCode)
+	}
+
+	interval := time.Duration(max(code.Interval, 5)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(max(code.ExpiresIn, 60))*time.Second)
+	defer cancel()
+
+	form = url.Values{
+		"client_id":   {d.ClientID},
+		"device_code": {code.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+	if d.ClientSecret != "" {
+		form.Set("client_secret", d.ClientSecret)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", errors.New("login timed out before the code was entered")
+		case <-time.After(interval):
+		}
+
+		var token map[string]any
+		if err := postForm(ctx, provider.tokenURL, form, &token); err != nil {
+			return "", fmt.Errorf("failed to complete login: %w", err)
+		}
+		errCode, _ := token["error"].(string)
+		switch errCode {
+		case "":
+			value, _ := token[provider.tokenField].(string)
+			if value == "" {
+				return "", fmt.Errorf("login response has no %s", provider.tokenField)
+			}
+			return value, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return "", errors.New("login was denied")
+		case "expired_token":
+			return "", errors.New("login code expired before it was entered")
+		default:
+			return "", fmt.Errorf("login failed: %s", errCode)
+		}
+	}
+}
+
+// postForm posts form to endpoint and decodes the JSON response into out.
+// Device flow errors come back as JSON with a 400 status, so those are decoded too.
+func postForm(ctx context.Context, endpoint string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s returned HTTP %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// getJSON fetches endpoint with an optional bearer token and decodes the
+// JSON response into out, returning the HTTP status
+func getJSON(ctx context.Context, endpoint, token string, out any) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// GitHubAuth accepts clients holding a GitHub token of an allowed user or
+// of an active member of an allowed organization
+type GitHubAuth struct {
+	Users []string
+	Orgs  []string
+
+	// APIURL defaults to https://api.github.com, set it for GitHub Enterprise
+	APIURL string
+}
+
+// Authenticate implements Authenticator, the identity is "github:LOGIN"
+func (g GitHubAuth) Authenticate(r *http.Request) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	api := strings.TrimRight(g.APIURL, "/")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	status, err := getJSON(r.Context(), api+"/user", token, &user)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach GitHub: %w", err)
+	}
+	if status != http.StatusOK || user.Login == "" {
+		return "", errors.New("GitHub token is invalid or expired")
+	}
+	identity := "github:" + user.Login
+
+	if slices.ContainsFunc(g.Users, func(u string) bool { return strings.EqualFold(u, user.Login) }) {
+		return identity, nil
+	}
+	for _, org := range g.Orgs {
+		var membership struct {
+			State string `json:"state"`
+		}
+		status, err := getJSON(r.Context(), api+"/user/memberships/orgs/"+url.PathEscape(org), token, &membership)
+		if err != nil {
+			return "", fmt.Errorf("failed to reach GitHub: %w", err)
+		}
+		if status == http.StatusOK && membership.State == "active" {
+			return identity, nil
+		}
+	}
+	return "", fmt.Errorf("GitHub user %s is not allowed", user.Login)
+}
+
+// GoogleAuth accepts clients holding a Google ID token issued to ClientID
+// for an allowed, verified email address or Workspace domain
+type GoogleAuth struct {
+	ClientID string
+	Emails   []string
+	Domains  []string
+
+	// TokenInfoURL defaults to Google's tokeninfo endpoint
+	TokenInfoURL string
+}
+
+// Authenticate implements Authenticator, the identity is "google:EMAIL"
+func (g GoogleAuth) Authenticate(r *http.Request) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	endpoint := g.TokenInfoURL
+	if endpoint == "" {
+		endpoint = "https://oauth2.googleapis.com/tokeninfo"
+	}
+
+	// tokeninfo checks the signature and expiry of the ID token for us
+	var info struct {
+		Audience      string `json:"aud"`
+		Email         string `json:"email"`
+		EmailVerified string `json:"email_verified"`
+		HostedDomain  string `json:"hd"`
+	}
+	status, err := getJSON(r.Context(), endpoint+"?id_token="+url.QueryEscape(token), "", &info)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach Google: %w", err)
+	}
+	if status != http.StatusOK || info.Email == "" {
+		return "", errors.New("Google ID token is invalid or expired")
+	}
+	// A token issued to another application must not be replayed here
+	if info.Audience != g.ClientID {
+		return "", errors.New("Google ID token was issued to another client")
+	}
+	if info.EmailVerified != "true" {
+		return "", fmt.Errorf("Google email %s is not verified", info.Email)
+	}
+	identity := "google:" + info.Email
+
+	if slices.ContainsFunc(g.Emails, func(e string) bool { return strings.EqualFold(e, info.Email) }) {
+		return identity, nil
+	}
+	if info.HostedDomain != "" && slices.ContainsFunc(g.Domains, func(d string) bool { return strings.EqualFold(d, info.HostedDomain) }) {
+		return identity, nil
+	}
+	return "", fmt.Errorf("Google user %s is not allowed", info.Email)
+}
@@ -0,0 +1,117 @@
+package linkterm
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxOSC bounds how much output is held back waiting for the end of an OSC
+// sequence
+const maxOSC = 4096
+
+// osc is an operating system command sequence, ESC ] code ; text, as used
+// for window titles and notifications
+type osc struct {
+	code string
+	text string
+}
+
+// oscScanner finds OSC sequences in terminal output that may split them
+// across messages
+type oscScanner struct {
+	partial []byte
+}
+
+// scan returns the OSC sequences completed in p, and whether p rings the
+// bell outside of them
+func (t *oscScanner) scan(p []byte) ([]osc, bool) {
+	data := p
+	if len(t.partial) > 0 {
+		data = append(t.partial, p...)
+		t.partial = nil
+	}
+
+	var found []osc
+	var bell bool
+	for {
+		start := bytes.Index(data, []byte("\x1b]"))
+		if start < 0 {
+			bell = bell || bytes.IndexByte(data, 0x07) >= 0
+			// A trailing ESC may start the next sequence
+			if len(data) > 0 && data[len(data)-1] == 0x1b {
+				t.partial = []byte{0x1b}
+			}
+			return found, bell
+		}
+		bell = bell || bytes.IndexByte(data[:start], 0x07) >= 0
+		data = data[start+2:]
+
+		// The sequence ends with BEL or ST, ESC \
+		end := bytes.IndexAny(data, "\x07\x1b")
+		if end < 0 || data[end] == 0x1b && end+1 == len(data) {
+			if len(data) < maxOSC {
+				t.partial = append([]byte("\x1b]"), data...)
+			}
+			return found, bell
+		}
+		if data[end] == 0x1b && data[end+1] != '\\' {
+			// Interrupted by another escape sequence
+			data = data[end:]
+			continue
+		}
+
+		if code, text, ok := strings.Cut(string(data[:end]), ";"); ok {
+			found = append(found, osc{code: code, text: text})
+		}
+		if data[end] == 0x07 {
+			data = data[end+1:]
+		} else {
+			data = data[end+2:]
+		}
+	}
+}
+
+// parseNotification returns the notification an OSC 9 or OSC 777 sequence
+// asks for, reporting false for other sequences
+func parseNotification(seq osc) (NotificationEvent, bool) {
+	switch seq.code {
+	case "9":
+		// ConEmu uses OSC 9 ; number ; ... for progress and other extensions
+		if kind, _, found := strings.Cut(seq.text, ";"); found && kind != "" && strings.Trim(kind, "0123456789") == "" {
+			return NotificationEvent{}, false
+		}
+		return NotificationEvent{Body: seq.text}, seq.text != ""
+	case "777":
+		rest, found := strings.CutPrefix(seq.text, "notify;")
+		if !found {
+			return NotificationEvent{}, false
+		}
+		title, body, _ := strings.Cut(rest, ";")
+		return NotificationEvent{Title: title, Body: body}, true
+	}
+	return NotificationEvent{}, false
+}
+
+// handleCommands reports title changes and notifications requested by
+// programs in the session, raising notifications with notifier if set
+func (c *Client) handleCommands(commands []osc, notifier *desktopNotifier) {
+	for _, seq := range commands {
+		switch seq.code {
+		case "0", "2":
+			c.emit(TitleChangedEvent{Title: seq.text})
+		case "9", "777":
+			notification, ok := parseNotification(seq)
+			if !ok {
+				continue
+			}
+			c.emit(notification)
+			if notifier != nil {
+				title := notification.Title
+				if title == "" {
+					title = c.windowTitle()
+				}
+				notifier.notify(title, notification.Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ListenPath is where servers with RemoteForward set listen on loopback
+// ports for clients, handing them each connection accepted
+const ListenPath = "/listen"
+
+// acceptTimeout is how long an accepted connection waits for its client to
+// take it
+const acceptTimeout = 30 * time.Second
+
+// PortForward forwards connections accepted on Listen to Target, both
+// host:port pairs
+type PortForward struct {
+	Listen string
+	Target string
+}
+
+// ParsePortForward reads a forwarding written as [BIND:]PORT:HOST:HOSTPORT,
+// as for ssh's -L and -R. BIND defaults to localhost, IPv6 addresses are
+// written in brackets.
+func ParsePortForward(spec string) (PortForward, error) {
+	parts := splitAddress(spec)
+	if len(parts) == 3 {
+		parts = append([]string{"localhost"}, parts...)
+	}
+	if len(parts) != 4 || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return PortForward{}, fmt.Errorf("invalid forwarding %q, expected [BIND:]PORT:HOST:HOSTPORT", spec)
+	}
+	return PortForward{
+		Listen: net.JoinHostPort(parts[0], parts[1]),
+		Target: net.JoinHostPort(parts[2], parts[3]),
+	}, nil
+}
+
+// ParseListenAddress reads an address to listen on written as [BIND:]PORT,
+// BIND defaulting to localhost
+func ParseListenAddress(spec string) (string, error) {
+	parts := splitAddress(spec)
+	if len(parts) == 1 {
+		parts = append([]string{"localhost"}, parts...)
+	}
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("invalid address %q, expected [BIND:]PORT", spec)
+	}
+	return net.JoinHostPort(parts[0], parts[1]), nil
+}
+
+// splitAddress splits spec at colons outside brackets, removing the brackets
+func splitAddress(spec string) []string {
+	var parts []string
+	for spec != "" {
+		var part string
+		if strings.HasPrefix(spec, "[") {
+			end := strings.Index(spec, "]")
+			if end < 0 {
+				return nil
+			}
+			part, spec = spec[1:end], spec[end+1:]
+			if spec != "" && !strings.HasPrefix(spec, ":") {
+				return nil
+			}
+			spec = strings.TrimPrefix(spec, ":")
+		} else {
+			part, spec, _ = strings.Cut(spec, ":")
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// pendingConn is a connection accepted for a client's remote forwarding,
+// waiting for the client to take it
+type pendingConn struct {
+	conn     net.Conn
+	identity string
+	timer    *time.Timer
+}
+
+// addPending keeps conn for the client to take under id, closing it if the
+// client does not in time
+func (s *Server) addPending(id, identity string, conn net.Conn) {
+	pending := &pendingConn{conn: conn, identity: identity}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pending == nil {
+		s.pending = make(map[string]*pendingConn)
+	}
+	s.pending[id] = pending
+	pending.timer = time.AfterFunc(acceptTimeout, func() {
+		s.pendingMu.Lock()
+		defer s.pendingMu.Unlock()
+		if s.pending[id] == pending {
+			delete(s.pending, id)
+			conn.Close()
+		}
+	})
+}
+
+// takePending returns the connection kept under id, if it was accepted for
+// identity
+func (s *Server) takePending(id, identity string) net.Conn {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	pending := s.pending[id]
+	if pending == nil || pending.identity != identity {
+		return nil
+	}
+	delete(s.pending, id)
+	pending.timer.Stop()
+	return pending.conn
+}
+
+// handleListen listens on the loopback address a client asks for, telling
+// it about each connection accepted over the WebSocket connection. The
+// client takes each connection with a connection of its own, passing the
+// accept query parameter.
+func (s *Server) handleListen(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	userAgent := r.UserAgent()
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.stats.countError("auth_failed")
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected remote forward, authentication failed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ListenPath, Detail: err.Error()})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, ListenPath) {
+		return
+	}
+
+	header := make(http.Header)
+	if s.HostKey != nil {
+		s.signHandshake(r, header)
+	}
+
+	if id := r.URL.Query().Get("accept"); id != "" {
+		s.servePending(w, r, header, id, identity)
+		return
+	}
+
+	addr := r.URL.Query().Get("addr")
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		http.Error(w, "Address must be host:port", http.StatusBadRequest)
+		return
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		s.stats.countError("forward_denied")
+		s.logger.Warn().Str("clientIP", clientIP).Str("addr", addr).Str("identity", identity).Msg("Rejected remote forward on a non-loopback address")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ListenPath, Identity: identity, Detail: "listening on " + addr + " not allowed"})
+		http.Error(w, "Only loopback addresses can be listened on", http.StatusForbidden)
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", clientIP).Str("addr", addr).Err(err).Msg("Failed to listen for remote forward")
+		http.Error(w, "Cannot listen on "+addr, http.StatusConflict)
+		return
+	}
+	s.serveListener(w, r, header, listener, identity, ListenPath)
+}
+
+// servePending hands the client the connection accepted under id
+func (s *Server) servePending(w http.ResponseWriter, r *http.Request, header http.Header, id, identity string) {
+	conn := s.takePending(id, identity)
+	if conn == nil {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+	wsConn, err := s.upgrade(w, r, header)
+	if err != nil {
+		conn.Close()
+		s.logger.Error().Str("clientIP", getClientIP(r)).Err(err).Msg("Error upgrading to WebSocket")
+		return
+	}
+	splice(newWSConn(wsConn), conn)
+}
+
+// serveListener tells the client about each connection listener accepts,
+// until the client goes away
+func (s *Server) serveListener(w http.ResponseWriter, r *http.Request, header http.Header, listener net.Listener, identity, path string) {
+	defer listener.Close()
+	clientIP := getClientIP(r)
+
+	wsConn, err := s.upgrade(w, r, header)
+	if err != nil {
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		s.stats.countError("upgrade")
+		return
+	}
+	defer wsConn.Close()
+
+	bound := listener.Addr().String()
+	s.logger.Info().Str("clientIP", clientIP).Str("addr", bound).Str("identity", identity).Msg("Listening for remote forward")
+	s.record(AuditEvent{Event: "forward_listening", ClientIP: clientIP, UserAgent: r.UserAgent(), Path: path, Identity: identity, Detail: bound})
+	defer s.logger.Info().Str("clientIP", clientIP).Str("addr", bound).Msg("Stopped listening for remote forward")
+
+	// The client going away stops the listener
+	go func() {
+		for {
+			if _, _, err := wsConn.ReadMessage(); err != nil {
+				listener.Close()
+				return
+			}
+		}
+	}()
+
+	if err := wsConn.WriteMessage(websocket.TextMessage, []byte("listening "+bound)); err != nil {
+		return
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		id, err := randomHex(16)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		s.addPending(id, identity, conn)
+		if err := wsConn.WriteMessage(websocket.TextMessage, []byte("accept "+id)); err != nil {
+			return
+		}
+	}
+}
+
+// ForwardLocal accepts connections on f.Listen locally and forwards each
+// through the server to f.Target, until ctx is done
+func (c *Client) ForwardLocal(ctx context.Context, f PortForward) error {
+	return c.serveLocal(ctx, f.Listen, func(dialer *websocket.Dialer, header http.Header, conn net.Conn) {
+		remote, err := c.openForward(ctx, dialer, header, c.URL, f.Target)
+		if err != nil {
+			c.logger.Warn().Str("target", f.Target).Err(err).Msg("Failed to forward connection")
+			conn.Close()
+			return
+		}
+		splice(conn, remote)
+	})
+}
+
+// ServeSOCKS runs a SOCKS5 proxy on listen locally, reaching the hosts its
+// clients ask for through the server, until ctx is done
+func (c *Client) ServeSOCKS(ctx context.Context, listen string) error {
+	return c.serveLocal(ctx, listen, func(dialer *websocket.Dialer, header http.Header, conn net.Conn) {
+		target, err := socksHandshake(conn)
+		if err != nil {
+			c.logger.Debug().Err(err).Msg("SOCKS handshake failed")
+			conn.Close()
+			return
+		}
+		remote, err := c.openForward(ctx, dialer, header, c.URL, target)
+		if err != nil {
+			c.logger.Warn().Str("target", target).Err(err).Msg("Failed to forward connection")
+			socksReply(conn, socksRefused)
+			conn.Close()
+			return
+		}
+		if err := socksReply(conn, socksSucceeded); err != nil {
+			conn.Close()
+			remote.Close()
+			return
+		}
+		splice(conn, remote)
+	})
+}
+
+// serveLocal calls handle with each connection accepted on listen, until
+// ctx is done
+func (c *Client) serveLocal(ctx context.Context, listen string, handle func(*websocket.Dialer, http.Header, net.Conn)) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	dialer, header := c.handshake()
+	c.logger.Info().Str("addr", listener.Addr().String()).Msg("Listening for forwarding")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go handle(dialer, header, conn)
+	}
+}
+
+// ForwardRemote has the server listen on f.Listen, one of its loopback
+// addresses, and forwards each connection it accepts to f.Target from the
+// client's side, until ctx is done
+func (c *Client) ForwardRemote(ctx context.Context, f PortForward) error {
+	return c.runRemote(ctx, f.Listen, func(conn net.Conn) {
+		target, err := net.DialTimeout("tcp", f.Target, forwardDialTimeout)
+		if err != nil {
+			c.logger.Warn().Str("target", f.Target).Err(err).Msg("Failed to forward connection")
+			conn.Close()
+			return
+		}
+		splice(conn, target)
+	})
+}
+
+// ServeReverseSOCKS has the server listen on listen, one of its loopback
+// addresses, and serves the connections it accepts as a SOCKS5 proxy into
+// the client's network, until ctx is done. Targets must match one of the
+// ReverseSOCKSAllow patterns, with none set every target is refused.
+func (c *Client) ServeReverseSOCKS(ctx context.Context, listen string) error {
+	return c.runRemote(ctx, listen, c.reverseSOCKS)
+}
+
+// reverseSOCKS serves a SOCKS5 connection from the server's side, reaching
+// the target from the client's
+func (c *Client) reverseSOCKS(conn net.Conn) {
+	target, err := socksHandshake(conn)
+	if err != nil {
+		c.logger.Debug().Err(err).Msg("SOCKS handshake failed")
+		conn.Close()
+		return
+	}
+	dest, err := parseHostPort(target)
+	if err == nil {
+		err = dest.resolve(context.Background())
+	}
+	if err != nil {
+		c.logger.Debug().Str("target", target).Err(err).Msg("Failed to resolve reverse SOCKS target")
+		socksReply(conn, socksRefused)
+		conn.Close()
+		return
+	}
+	if !dest.allowedBy(c.ReverseSOCKSAllow) {
+		c.logger.Debug().Str("target", target).Msg("Refused reverse SOCKS connection to a target not allowed")
+		socksReply(conn, socksNotAllowed)
+		conn.Close()
+		return
+	}
+	local, err := dest.dial()
+	if err != nil {
+		c.logger.Debug().Str("target", target).Err(err).Msg("Failed to reach reverse SOCKS target")
+		socksReply(conn, socksRefused)
+		conn.Close()
+		return
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		conn.Close()
+		local.Close()
+		return
+	}
+	c.logger.Debug().Str("target", target).Msg("Server connected through reverse SOCKS")
+	splice(conn, local)
+}
+
+// runRemote has the server listen on addr and calls handle with each
+// connection it accepts, until ctx is done
+func (c *Client) runRemote(ctx context.Context, addr string, handle func(net.Conn)) error {
+	listener, err := c.listenRemote(ctx, ListenPath, url.Values{"addr": {addr}})
+	if err != nil {
+		return err
+	}
+	defer listener.close()
+	stop := context.AfterFunc(ctx, listener.close)
+	defer stop()
+
+	c.logger.Info().Str("addr", listener.addr).Msg("Server listening for forwarding")
+	err = c.serveRemote(listener, handle)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("server stopped listening on %s: %w", listener.addr, err)
+}
+
+// remoteListener is a listener the server runs for the client, reporting
+// the connections it accepts over a WebSocket connection
+type remoteListener struct {
+	ws       *websocket.Conn
+	addr     string
+	endpoint string
+	dialer   *websocket.Dialer
+	header   http.Header
+}
+
+func (l *remoteListener) close() {
+	l.ws.Close()
+}
+
+// listenRemote asks the server to listen for the client at endpoint, which
+// takes what to listen on from query
+func (c *Client) listenRemote(ctx context.Context, endpoint string, query url.Values) (*remoteListener, error) {
+	dialer, header := c.handshake()
+	listen, err := endpointURL(c.URL, endpoint, query)
+	if err != nil {
+		return nil, err
+	}
+	ws, resp, err := dialer.DialContext(ctx, listen, header)
+	if err != nil {
+		if resp != nil {
+			reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return nil, fmt.Errorf("server refused to listen: HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(reason)))
+		}
+		return nil, fmt.Errorf("failed to connect to terminal server: %w: %w", ErrHandshake, err)
+	}
+	if err := c.checkHostKey(listen, "", resp); err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	_, message, err := ws.ReadMessage()
+	bound, ok := strings.CutPrefix(string(message), "listening ")
+	if err != nil || !ok {
+		ws.Close()
+		return nil, errors.New("server did not start listening")
+	}
+	return &remoteListener{ws: ws, addr: bound, endpoint: endpoint, dialer: dialer, header: header}, nil
+}
+
+// serveRemote calls handle with each connection the server accepts, until
+// the listener is closed
+func (c *Client) serveRemote(listener *remoteListener, handle func(net.Conn)) error {
+	for {
+		_, message, err := listener.ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		id, ok := strings.CutPrefix(string(message), "accept ")
+		if !ok {
+			continue
+		}
+		go func() {
+			accept, err := endpointURL(c.URL, listener.endpoint, url.Values{"accept": {id}})
+			if err != nil {
+				return
+			}
+			conn, _, err := listener.dialer.Dial(accept, listener.header)
+			if err != nil {
+				c.logger.Debug().Err(err).Msg("Failed to take forwarded connection")
+				return
+			}
+			handle(newWSConn(conn))
+		}()
+	}
+}
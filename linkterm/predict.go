@@ -0,0 +1,88 @@
+package linkterm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxPredictions bounds how many unconfirmed keystrokes are tracked
+const maxPredictions = 256
+
+// predictor echoes printable keystrokes locally before the server does, so
+// typing feels immediate over slow links. Predictions are underlined and
+// replaced by the real echo once it arrives. Predictions are only shown while
+// the server has been echoing keystrokes as typed, so passwords and
+// full-screen programs are left alone.
+type predictor struct {
+	mu  sync.Mutex
+	out io.Writer
+
+	// confirmed is set once the server echoed a keystroke back verbatim
+	confirmed bool
+	// pending holds keystrokes sent but not echoed yet
+	pending []byte
+	// shown is how many predicted characters are currently on screen
+	shown int
+}
+
+func newPredictor(out io.Writer) *predictor {
+	return &predictor{out: out}
+}
+
+// input records keystrokes sent to the server, displaying them if echo is confirmed
+func (p *predictor) input(keys []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, key := range keys {
+		if key < 0x20 || key > 0x7e || len(p.pending) >= maxPredictions {
+			// Control keys may do anything, wait for the server to confirm echo again
+			p.pending = nil
+			p.confirmed = false
+			continue
+		}
+		p.pending = append(p.pending, key)
+		if p.confirmed {
+			fmt.Fprintf(p.out, "\033[4m%c\033[24m", key)
+			p.shown++
+		}
+	}
+}
+
+// output writes server output, replacing predictions shown so far
+func (p *predictor) output(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shown > 0 {
+		// Move back over the predictions and blank them, the real echo follows
+		fmt.Fprintf(p.out, "\033[%dD\033[%dX", p.shown, p.shown)
+		p.shown = 0
+	}
+
+	matched := 0
+	for matched < len(data) && matched < len(p.pending) && data[matched] == p.pending[matched] {
+		matched++
+	}
+	if matched > 0 {
+		p.confirmed = true
+		p.pending = p.pending[matched:]
+	} else if len(p.pending) > 0 {
+		p.confirmed = false
+		p.pending = nil
+	}
+
+	n, err := p.out.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	// Output that was only echo leaves the cursor where the remaining
+	// predictions belong
+	if matched == len(data) && p.confirmed && len(p.pending) > 0 {
+		fmt.Fprintf(p.out, "\033[4m%s\033[24m", p.pending)
+		p.shown = len(p.pending)
+	}
+	return n, nil
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package linkterm
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the kernel's USER_HZ, the unit of CPU times in /proc, which
+// is 100 on all mainstream architectures
+const clockTicks = 100
+
+// sessionMembers lists the processes belonging to session sid, which includes
+// jobs that a job-control shell moved into process groups of their own
+func sessionMembers(sid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fields := procStat(pid)
+		if len(fields) < 4 {
+			continue
+		}
+		if session, err := strconv.Atoi(fields[3]); err == nil && session == sid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// processUsage sums the CPU time and resident memory of pids, skipping
+// processes that have exited meanwhile
+func processUsage(pids []int) (ProcessUsage, bool) {
+	var usage ProcessUsage
+	pageSize := int64(os.Getpagesize())
+	for _, pid := range pids {
+		// utime and stime are fields 14 and 15 of the stat line, rss field 24
+		fields := procStat(pid)
+		if len(fields) < 22 {
+			continue
+		}
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		rss, _ := strconv.ParseInt(fields[21], 10, 64)
+		usage.CPUSeconds += float64(utime+stime) / clockTicks
+		usage.RSSBytes += rss * pageSize
+		usage.Processes++
+	}
+	return usage, true
+}
+
+// procStat returns the fields of a process's stat line that follow the
+// command name, starting with field 3, the state. The command name may
+// contain spaces, so fields are counted after its closing paren.
+func procStat(pid int) []string {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package linkterm
+
+// sessionMembers lists the processes belonging to session sid. Only Linux
+// supports this, elsewhere no processes are reported.
+func sessionMembers(sid int) []int {
+	return nil
+}
+
+// processUsage sums the resources used by pids, which is only supported on Linux
+func processUsage(pids []int) (ProcessUsage, bool) {
+	return ProcessUsage{}, false
+}
@@ -0,0 +1,120 @@
+package linkterm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialProxyCommand runs ProxyCommand to reach addr and returns a connection
+// over the command's stdin and stdout. %h in the command is replaced by the
+// host of addr, %p by its port and %% by a single %.
+func (c *Client) dialProxyCommand(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	command := strings.NewReplacer("%h", host, "%p", port, "%%", "%").Replace(c.ProxyCommand)
+
+	cmd := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	}
+	// The command's diagnostics are for the user, as with ssh
+	cmd.Stderr = os.Stderr
+
+	// Pipes of our own, as those of os.Pipe support read deadlines where
+	// the platform allows
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		return nil, err
+	}
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	err = cmd.Start()
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return nil, fmt.Errorf("proxy command: %w", err)
+	}
+
+	c.logger.Debug().Str("command", command).Msg("Started proxy command")
+	return &commandConn{cmd: cmd, stdin: stdinWriter, stdout: stdoutReader, addr: commandAddr(command)}, nil
+}
+
+// commandConn is a connection over the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	addr   commandAddr
+
+	closeOnce sync.Once
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+// Close closes the command's streams and stops it
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		c.stdout.Close()
+		c.cmd.Process.Kill()
+		go c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return c.addr }
+func (c *commandConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for reads, if the platform's pipes
+// support one
+func (c *commandConn) SetReadDeadline(t time.Time) error {
+	return ignoreNoDeadline(c.stdout.SetReadDeadline(t))
+}
+
+// SetWriteDeadline sets the deadline for writes, if the platform's pipes
+// support one
+func (c *commandConn) SetWriteDeadline(t time.Time) error {
+	return ignoreNoDeadline(c.stdin.SetWriteDeadline(t))
+}
+
+// ignoreNoDeadline drops the error of pipes without deadlines, which then
+// rely on the command ending
+func ignoreNoDeadline(err error) error {
+	if errors.Is(err, os.ErrNoDeadline) {
+		return nil
+	}
+	return err
+}
+
+// commandAddr is the address of a connection through a proxy command, the
+// command itself
+type commandAddr string
+
+func (a commandAddr) Network() string { return "proxy-command" }
+func (a commandAddr) String() string  { return string(a) }
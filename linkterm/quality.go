@@ -0,0 +1,108 @@
+package linkterm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// qualityPing is how often the server is pinged to measure the connection
+// when quality is logged without keepalives
+const qualityPing = 2 * time.Second
+
+// ConnectionQuality estimates the round trip to the server from the timing
+// of keepalive pings
+type ConnectionQuality struct {
+	// RTT is the smoothed round trip time
+	RTT time.Duration
+	// Jitter is the smoothed variation between consecutive round trips
+	Jitter time.Duration
+	// Samples counts the pings answered, the estimate is unknown while it is 0
+	Samples int
+}
+
+// String formats the estimate for humans
+func (q ConnectionQuality) String() string {
+	if q.Samples == 0 {
+		return "round trip unknown"
+	}
+	return fmt.Sprintf("round trip %s, jitter %s", q.RTT.Round(100*time.Microsecond), q.Jitter.Round(100*time.Microsecond))
+}
+
+// qualityEstimator smooths round trip samples like TCP does for RTT and RTP
+// for jitter
+type qualityEstimator struct {
+	mu      sync.Mutex
+	quality ConnectionQuality
+	last    time.Duration
+}
+
+// sample adds a measured round trip
+func (e *qualityEstimator) sample(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q := &e.quality
+	if q.Samples == 0 {
+		q.RTT = rtt
+	} else {
+		q.RTT += (rtt - q.RTT) / 8
+		q.Jitter += (max(rtt-e.last, e.last-rtt) - q.Jitter) / 16
+	}
+	e.last = rtt
+	q.Samples++
+}
+
+// reset forgets the samples of a previous connection
+func (e *qualityEstimator) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.quality, e.last = ConnectionQuality{}, 0
+}
+
+// get returns the current estimate
+func (e *qualityEstimator) get() ConnectionQuality {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.quality
+}
+
+// Quality returns the estimated quality of the current connection, measured
+// while KeepAlive or QualityLog is set
+func (c *Client) Quality() ConnectionQuality {
+	return c.quality.get()
+}
+
+// pingPayload returns a ping carrying the time it was sent
+func pingPayload(now time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+}
+
+// pongRTT returns the round trip of the ping answered by a pong with
+// payload, reporting false for pongs not carrying a send time
+func pongRTT(payload string, now time.Time) (time.Duration, bool) {
+	if len(payload) != 8 {
+		return 0, false
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(payload))))
+	rtt := now.Sub(sent)
+	return rtt, rtt >= 0
+}
+
+// logQuality prints the connection's quality every QualityLog until done is
+// closed, as a notice line between the session's output
+func (c *Client) logQuality(done <-chan struct{}) {
+	ticker := time.NewTicker(c.QualityLog)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			quality := c.Quality()
+			c.logger.Debug().Dur("rtt", quality.RTT).Dur("jitter", quality.Jitter).Int("samples", quality.Samples).Msg("Connection quality")
+			fmt.Fprintf(c.stderr(), "\r\n\033[2m[linkterm] %s\033[0m\r\n", quality)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package linkterm
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding up to burst bytes, refilled at rate
+// bytes per second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a full bucket, or nil if rate is not positive. A
+// burst smaller than rate is raised to rate.
+func newRateLimiter(rate, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < rate {
+		burst = rate
+	}
+	return &rateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes up to n bytes from the bucket and returns how many were granted.
+// A nil limiter grants everything.
+func (l *rateLimiter) allow(n int) int {
+	if l == nil {
+		return n
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	granted := n
+	if float64(granted) > l.tokens {
+		granted = int(l.tokens)
+	}
+	l.tokens -= float64(granted)
+	return granted
+}
@@ -0,0 +1,75 @@
+package linkterm
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// sessionReport is the outcome of a client session, written as JSON for
+// scripts wrapping the client
+type sessionReport struct {
+	URL         string     `json:"url"`
+	Connected   bool       `json:"connected"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	// Duration is how long the session lasted, in seconds
+	Duration float64 `json:"duration"`
+	// Reconnects counts the connection attempts retried
+	Reconnects    int    `json:"reconnects"`
+	BytesSent     int64  `json:"bytesSent"`
+	BytesReceived int64  `json:"bytesReceived"`
+	ExitCode      *int   `json:"exitCode"`
+	CloseReason   string `json:"closeReason,omitempty"`
+	Error         string `json:"error,omitempty"`
+
+	done chan struct{}
+}
+
+// collectReport starts filling a report for url from the client's events,
+// until events is closed
+func collectReport(url string, events <-chan Event) *sessionReport {
+	report := &sessionReport{URL: url, done: make(chan struct{})}
+	go func() {
+		defer close(report.done)
+		for e := range events {
+			switch e := e.(type) {
+			case ConnectedEvent:
+				now := time.Now()
+				report.Connected = true
+				report.ConnectedAt = &now
+			case ReconnectingEvent:
+				report.Reconnects++
+			case ClosedEvent:
+				if report.ConnectedAt != nil {
+					report.Duration = time.Since(*report.ConnectedAt).Seconds()
+				}
+				report.BytesSent = e.BytesSent
+				report.BytesReceived = e.BytesReceived
+				if e.ExitCode >= 0 {
+					report.ExitCode = &e.ExitCode
+				}
+				report.CloseReason = e.Reason
+			}
+		}
+	}()
+	return report
+}
+
+// write waits for the last event and writes the report to path, or to
+// stdout if path is "-". err is what ended the session, if anything.
+func (r *sessionReport) write(path string, err error) error {
+	<-r.done
+	if err != nil {
+		r.Error = err.Error()
+	}
+	data, jsonErr := json.MarshalIndent(r, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,194 @@
+package linkterm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// resizeSettle is how long the terminal size must stay put before it is
+	// sent, so dragging a window sends one resize instead of dozens
+	resizeSettle = 50 * time.Millisecond
+	// resizeAckTimeout is how long to wait for the server to confirm a resize
+	// before sending it again
+	resizeAckTimeout = 2 * time.Second
+	// resizeAttempts is how often a resize is sent before giving up on it
+	resizeAttempts = 3
+)
+
+// termSize is a terminal size in columns and rows
+type termSize struct {
+	cols, rows int
+}
+
+// resizeAck is the server's answer to a resize request
+type resizeAck struct {
+	size termSize
+	ok   bool
+}
+
+// formatResize returns the message asking the server to resize to size
+func formatResize(size termSize) []byte {
+	return []byte(fmt.Sprintf("resize:%d:%d", size.cols, size.rows))
+}
+
+// formatResizeAck returns the message confirming or refusing a resize
+func formatResizeAck(size termSize, ok bool) []byte {
+	if ok {
+		return []byte(fmt.Sprintf("resized:%d:%d", size.cols, size.rows))
+	}
+	return []byte(fmt.Sprintf("resize-failed:%d:%d", size.cols, size.rows))
+}
+
+// parseResize parses a resize request, reporting false if p is not one
+func parseResize(p []byte) (termSize, bool) {
+	rest, found := strings.CutPrefix(string(p), "resize:")
+	if !found {
+		return termSize{}, false
+	}
+	return parseSize(rest)
+}
+
+// parseResizeAck parses a resize acknowledgement, reporting false if p is not one
+func parseResizeAck(p []byte) (resizeAck, bool) {
+	if rest, found := strings.CutPrefix(string(p), "resized:"); found {
+		size, valid := parseSize(rest)
+		return resizeAck{size: size, ok: true}, valid
+	}
+	if rest, found := strings.CutPrefix(string(p), "resize-failed:"); found {
+		size, valid := parseSize(rest)
+		return resizeAck{size: size}, valid
+	}
+	return resizeAck{}, false
+}
+
+// parseSize parses "cols:rows" with both positive
+func parseSize(text string) (termSize, bool) {
+	colsText, rowsText, found := strings.Cut(text, ":")
+	if !found {
+		return termSize{}, false
+	}
+	cols, err1 := strconv.Atoi(colsText)
+	rows, err2 := strconv.Atoi(rowsText)
+	if err1 != nil || err2 != nil || cols <= 0 || rows <= 0 {
+		return termSize{}, false
+	}
+	return termSize{cols: cols, rows: rows}, true
+}
+
+// syncSize keeps the remote terminal the size of the local one until done is
+// closed, closing sized once the initial size was sent. A status line, if
+// given, is fitted to the local terminal and takes its bottom row. Bursts of size
+// changes are coalesced. If the server acknowledges resizes, a size it has
+// not confirmed is sent again and given up on with a warning after
+// resizeAttempts.
+func (c *Client) syncSize(conn *safeConn, ack bool, acks <-chan resizeAck, status *statusLine, sized chan<- struct{}, done <-chan struct{}) {
+	// Sizes come from the embedder if it provides them, or the process's terminal
+	size := c.Size
+	resized := c.Resized
+	var changed chan os.Signal
+	if size == nil {
+		size = terminalSize
+		changed = setupResizeHandler()
+		defer stopResizeHandler(changed)
+	}
+
+	var sizedOnce sync.Once
+	signalSized := func() { sizedOnce.Do(func() { close(sized) }) }
+	defer signalSized()
+
+	settle := time.NewTimer(0)
+	retry := time.NewTimer(0)
+	retry.Stop()
+
+	// remote is the size the server's terminal is known to have, sent the
+	// size last sent and pending whether that awaits confirmation
+	var remote, sent termSize
+	var pending bool
+	attempts := 0
+	send := func(size termSize) bool {
+		if err := conn.WriteMessage(websocket.TextMessage, formatResize(size)); err != nil {
+			if !isClosedErr(err) {
+				c.logger.Warn().Err(err).Msg("Could not send terminal size")
+			}
+			return false
+		}
+		sent = size
+		attempts++
+		if ack {
+			pending = true
+			retry.Reset(resizeAckTimeout)
+		} else {
+			remote = size
+			c.emit(ResizedEvent{Cols: size.cols, Rows: size.rows})
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-changed:
+			// Window drags report many sizes, send the one they settle on
+			settle.Reset(resizeSettle)
+		case _, ok := <-resized:
+			if !ok {
+				resized = nil
+				continue
+			}
+			settle.Reset(resizeSettle)
+		case <-settle.C:
+			width, height, err := size()
+			if err != nil {
+				c.logger.Warn().Err(err).Msg("Could not get terminal size")
+				signalSized()
+				continue
+			}
+			if status != nil && width > 0 && height > 0 {
+				height = status.resize(width, height)
+			}
+			current := termSize{cols: width, rows: height}
+			if width <= 0 || height <= 0 || pending && current == sent || !pending && current == remote {
+				signalSized()
+				continue
+			}
+			attempts = 0
+			if !send(current) {
+				return
+			}
+			signalSized()
+		case a := <-acks:
+			if !pending || a.size != sent {
+				// Confirms a size that has since been replaced
+				continue
+			}
+			pending = false
+			retry.Stop()
+			if a.ok {
+				remote = a.size
+				c.emit(ResizedEvent{Cols: a.size.cols, Rows: a.size.rows})
+			} else {
+				c.logger.Warn().Int("cols", a.size.cols).Int("rows", a.size.rows).Msg("Server could not resize the terminal")
+			}
+		case <-retry.C:
+			if !pending {
+				continue
+			}
+			if attempts >= resizeAttempts {
+				pending = false
+				c.logger.Warn().Int("cols", sent.cols).Int("rows", sent.rows).Msg("Server did not confirm the terminal size")
+				continue
+			}
+			if !send(sent) {
+				return
+			}
+		}
+	}
+}
@@ -3,9 +3,12 @@
 package linkterm
 
 import (
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
+
+	"golang.org/x/term"
 )
 
 func setupResizeHandler() chan os.Signal {
@@ -13,3 +16,17 @@ func setupResizeHandler() chan os.Signal {
 	signal.Notify(sigwinchCh, syscall.SIGWINCH)
 	return sigwinchCh
 }
+
+func stopResizeHandler(sigwinchCh chan os.Signal) {
+	signal.Stop(sigwinchCh)
+}
+
+// terminalSize returns the size of the terminal on stdin
+func terminalSize() (int, int, error) {
+	return term.GetSize(int(os.Stdin.Fd()))
+}
+
+// terminalInput returns the reader of keyboard input
+func terminalInput() io.Reader {
+	return os.Stdin
+}
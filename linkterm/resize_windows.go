@@ -3,33 +3,127 @@
 package linkterm
 
 import (
+	"io"
 	"os"
-	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
 
+	"golang.org/x/sys/windows"
 	"golang.org/x/term"
 )
 
+var procReadConsoleInputW = windows.NewLazySystemDLL("kernel32.dll").NewProc("ReadConsoleInputW")
+
+// consoleResized is signaled by the console input reader when the console
+// reports a new size
+var consoleResized = make(chan os.Signal, 1)
+
+// inputRecord is the console's INPUT_RECORD, event holds the union of the
+// event records
+type inputRecord struct {
+	eventType uint16
+	_         uint16
+	event     [4]uint32
+}
+
+// keyEventRecord is the console's KEY_EVENT_RECORD
+type keyEventRecord struct {
+	keyDown         int32
+	repeatCount     uint16
+	virtualKeyCode  uint16
+	virtualScanCode uint16
+	char            uint16
+	controlKeyState uint32
+}
+
+// setupResizeHandler asks the console to report size changes as input events,
+// which terminalInput turns into signals on the returned channel
 func setupResizeHandler() chan os.Signal {
-	sigwinchCh := make(chan os.Signal, 1)
-
-	// On Windows, poll for terminal size changes
-	go func() {
-		var lastWidth, lastHeight int
-		lastWidth, lastHeight, _ = term.GetSize(int(os.Stdin.Fd()))
-		ticker := time.NewTicker(250 * time.Millisecond)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			width, height, err := term.GetSize(int(os.Stdin.Fd()))
-			if err != nil {
-				continue
-			}
-			if width != lastWidth || height != lastHeight {
-				lastWidth, lastHeight = width, height
-				sigwinchCh <- os.Interrupt // Use Interrupt as a dummy signal
+	handle := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err == nil {
+		windows.SetConsoleMode(handle, mode|windows.ENABLE_WINDOW_INPUT)
+	}
+	return consoleResized
+}
+
+func stopResizeHandler(sigwinchCh chan os.Signal) {
+	// The console keeps reporting sizes until the terminal mode is restored
+}
+
+// terminalSize returns the size of the console window, which only the
+// output handle knows
+func terminalSize() (int, int, error) {
+	return term.GetSize(int(os.Stdout.Fd()))
+}
+
+// terminalInput returns the reader of keyboard input. On a console, input is
+// read as events so size changes arrive without polling.
+func terminalInput() io.Reader {
+	handle := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return os.Stdin
+	}
+	return &consoleInput{handle: handle}
+}
+
+// consoleInput reads key events from a console as UTF-8, signaling
+// consoleResized for size events
+type consoleInput struct {
+	handle  windows.Handle
+	pending []byte
+	// surrogate holds the first half of a character split over two events
+	surrogate rune
+}
+
+// Read returns typed input, blocking until there is some
+func (c *consoleInput) Read(p []byte) (int, error) {
+	records := make([]inputRecord, 16)
+	for len(c.pending) == 0 {
+		var n uint32
+		r, _, err := procReadConsoleInputW.Call(uintptr(c.handle), uintptr(unsafe.Pointer(&records[0])),
+			uintptr(len(records)), uintptr(unsafe.Pointer(&n)))
+		if r == 0 {
+			return 0, err
+		}
+		for _, record := range records[:n] {
+			switch record.eventType {
+			case windows.WINDOW_BUFFER_SIZE_EVENT:
+				select {
+				case consoleResized <- os.Interrupt: // Use Interrupt as a dummy signal
+				default:
+				}
+			case windows.KEY_EVENT:
+				c.key((*keyEventRecord)(unsafe.Pointer(&record.event)))
 			}
 		}
-	}()
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// key appends the characters a key event typed to the pending input. In
+// virtual terminal input mode, special keys arrive as escape sequences.
+func (c *consoleInput) key(event *keyEventRecord) {
+	if event.keyDown == 0 || event.char == 0 {
+		return
+	}
+
+	char := rune(event.char)
+	if utf16.IsSurrogate(char) {
+		if c.surrogate == 0 {
+			c.surrogate = char
+			return
+		}
+		char = utf16.DecodeRune(c.surrogate, char)
+	}
+	c.surrogate = 0
 
-	return sigwinchCh
+	for i := uint16(0); i < max(event.repeatCount, 1); i++ {
+		c.pending = utf8.AppendRune(c.pending, char)
+	}
 }
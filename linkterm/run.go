@@ -0,0 +1,268 @@
+package linkterm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultStepTimeout is how long a script step may run unless the script
+	// says otherwise
+	DefaultStepTimeout = 30 * time.Second
+	// maxStepOutput bounds the output kept for each step, the end is kept
+	maxStepOutput = 1 << 20
+	// shellReadyTimeout is how long the shell may take to start
+	shellReadyTimeout = 30 * time.Second
+)
+
+// errStepTimeout is returned for a command that did not complete in time
+var errStepTimeout = errors.New("timed out")
+
+// stepMarker matches the line printed after each step, carrying the step
+// number and the command's exit status
+var stepMarker = regexp.MustCompile(`__LTRUN_(\d+)_(\d+)__`)
+
+// terminalControl matches escape sequences, which are removed from captured output
+var terminalControl = regexp.MustCompile(`\x1b(\[[0-9;?<=>]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[()][0-9A-Za-z]|[=>])`)
+
+// ScriptStep is a command run by Client.Run
+type ScriptStep struct {
+	// Command is typed into the shell, the step passes if it exits with status 0
+	Command string
+
+	// Expect are patterns the command's output must all match
+	Expect []*regexp.Regexp
+
+	// Timeout fails the step if the command has not completed in time
+	Timeout time.Duration
+
+	// Line is where the step starts in its script
+	Line int
+}
+
+// StepResult reports how a script step went
+type StepResult struct {
+	Line     int           `json:"line"`
+	Command  string        `json:"command"`
+	Passed   bool          `json:"passed"`
+	ExitCode int           `json:"exitCode"`
+	Duration time.Duration `json:"durationNs"`
+	Output   string        `json:"output"`
+	Failure  string        `json:"failure,omitempty"`
+}
+
+// ParseScript reads a script of steps, one directive per line:
+//
+//	send COMMAND     starts a step running COMMAND
+//	expect REGEXP    the step's output must match REGEXP, can be repeated
+//	timeout DURATION the step fails if it runs longer, before the first
+//	                 send it sets the default for all steps
+//
+// Blank lines and lines starting with # are ignored.
+func ParseScript(r io.Reader) ([]ScriptStep, error) {
+	var steps []ScriptStep
+	timeout := DefaultStepTimeout
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		directive, arg, _ := strings.Cut(text, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch directive {
+		case "send":
+			if arg == "" {
+				return nil, fmt.Errorf("line %d: send needs a command", line)
+			}
+			steps = append(steps, ScriptStep{Command: arg, Timeout: timeout, Line: line})
+		case "expect":
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("line %d: expect before the first send", line)
+			}
+			pattern, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			steps[len(steps)-1].Expect = append(steps[len(steps)-1].Expect, pattern)
+		case "timeout":
+			d, err := time.ParseDuration(arg)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("line %d: invalid timeout %q", line, arg)
+			}
+			if len(steps) == 0 {
+				timeout = d
+			} else {
+				steps[len(steps)-1].Timeout = d
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown directive %q, expected send, expect or timeout", line, directive)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// shellRun types commands into a shell over a connection and collects what
+// they print
+type shellRun struct {
+	conn   *websocket.Conn
+	output <-chan []byte
+	marker int
+	buf    []byte
+}
+
+// Run opens a session, quiets the shell's echo and prompt, then types each
+// step's command and waits for it to complete. It stops at the first failed
+// step unless keepGoing is set, and returns the results of the steps run.
+// The server's shell must be POSIX compatible.
+func (c *Client) Run(ctx context.Context, steps []ScriptStep, keepGoing bool) ([]StepResult, error) {
+	conn, _, err := c.open(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if c.MaxMessageSize > 0 {
+		conn.SetReadLimit(c.MaxMessageSize)
+	}
+
+	output := make(chan []byte, 64)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(output)
+		for {
+			messageType, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			select {
+			case output <- p:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	run := &shellRun{conn: conn, output: output}
+	if _, _, err := run.do(ctx, "stty -echo 2>/dev/null; PS1= PS2=", shellReadyTimeout); err != nil {
+		return nil, fmt.Errorf("shell did not become ready: %w", err)
+	}
+
+	var results []StepResult
+	for _, step := range steps {
+		timeout := step.Timeout
+		if timeout <= 0 {
+			timeout = DefaultStepTimeout
+		}
+
+		start := time.Now()
+		out, status, err := run.do(ctx, step.Command, timeout)
+		result := StepResult{
+			Line:     step.Line,
+			Command:  step.Command,
+			ExitCode: status,
+			Duration: time.Since(start),
+			Output:   out,
+		}
+		switch {
+		case err != nil:
+			result.Failure = err.Error()
+		case status != 0:
+			result.Failure = fmt.Sprintf("exited with status %d", status)
+		default:
+			for _, pattern := range step.Expect {
+				if !pattern.MatchString(out) {
+					result.Failure = fmt.Sprintf("output does not match %q", pattern.String())
+					break
+				}
+			}
+		}
+		result.Passed = result.Failure == ""
+		results = append(results, result)
+
+		if errors.Is(err, errStepTimeout) {
+			// Interrupt the command so the shell takes the next one
+			conn.WriteMessage(websocket.TextMessage, []byte{3})
+			if _, _, err := run.do(ctx, ":", shellReadyTimeout); err != nil {
+				return results, fmt.Errorf("shell did not recover after a timeout: %w", err)
+			}
+		} else if err != nil {
+			return results, err
+		}
+		if !result.Passed && !keepGoing {
+			break
+		}
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return results, nil
+}
+
+// do types command followed by a marker line, and returns what the command
+// printed and its exit status once the marker arrives. The status is -1 if
+// the command did not complete.
+func (r *shellRun) do(ctx context.Context, command string, timeout time.Duration) (string, int, error) {
+	r.marker++
+	// The marker line is typed separately so comments and trailing & in the
+	// command cannot swallow it. %s keeps an echo of it from matching.
+	line := fmt.Sprintf("%s\rprintf '\\n__LTRUN_%%s_%%s__\\n' %d $?\r", command, r.marker)
+	if err := r.conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+		return "", -1, fmt.Errorf("disconnected: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		for _, m := range stepMarker.FindAllSubmatchIndex(r.buf, -1) {
+			if n, _ := strconv.Atoi(string(r.buf[m[2]:m[3]])); n == r.marker {
+				status, _ := strconv.Atoi(string(r.buf[m[4]:m[5]]))
+				out := cleanOutput(r.buf[:m[0]])
+				r.buf = append(r.buf[:0], r.buf[m[1]:]...)
+				return out, status, nil
+			}
+		}
+
+		select {
+		case p, ok := <-r.output:
+			if !ok {
+				return cleanOutput(r.buf), -1, errors.New("disconnected")
+			}
+			r.buf = append(r.buf, p...)
+			if over := len(r.buf) - maxStepOutput; over > 0 {
+				r.buf = r.buf[over:]
+			}
+		case <-timer.C:
+			out := cleanOutput(r.buf)
+			r.buf = r.buf[:0]
+			return out, -1, fmt.Errorf("%w after %s", errStepTimeout, timeout)
+		case <-ctx.Done():
+			return cleanOutput(r.buf), -1, ctx.Err()
+		}
+	}
+}
+
+// cleanOutput turns terminal output into plain text lines
+func cleanOutput(p []byte) string {
+	p = terminalControl.ReplaceAll(p, nil)
+	p = bytes.ReplaceAll(p, []byte("\r\n"), []byte("\n"))
+	p = bytes.ReplaceAll(p, []byte("\r"), nil)
+	return strings.Trim(string(p), "\n")
+}
@@ -0,0 +1,258 @@
+package linkterm
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// scriptMaxSteps bounds the work of a single handler call, so a runaway
+// script cannot hang sessions
+const scriptMaxSteps = 1_000_000
+
+// Script handlers are the functions a script may define, called with the
+// session and, for input and output, the data as a string
+const (
+	ScriptOnConnect = "on_connect"
+	ScriptOnInput   = "on_input"
+	ScriptOnOutput  = "on_output"
+	ScriptOnClose   = "on_close"
+)
+
+// Script is a Starlark script whose handlers run on session events. Handlers
+// get a session with id, path, client_ip, identity and tags, and act with the
+// builtins deny(reason), which refuses a connection in on_connect and
+// discards input in on_input, annotate(name, value), which tags the session,
+// and notify(text), which shows the client a notice:
+//
+//	def on_input(session, data):
+//	    if "rm -rf /" in data:
+//	        deny("not on this host")
+type Script struct {
+	path    string
+	globals starlark.StringDict
+}
+
+// scriptSession is the session handed to a script's handlers
+type scriptSession struct {
+	id, path, clientIP, identity string
+	tags                         map[string]string
+}
+
+// scriptActions are what a handler call asked for
+type scriptActions struct {
+	denied  bool
+	reason  string
+	tags    map[string]string
+	notices []string
+}
+
+// scriptCallKey is the thread local holding the actions of a call
+const scriptCallKey = "linkterm.actions"
+
+// LoadScript runs the Starlark script at path, which defines the handlers
+func LoadScript(path string) (*Script, error) {
+	thread := &starlark.Thread{Name: path}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, path, nil, scriptBuiltins)
+	if err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			return nil, errors.New(evalErr.Backtrace())
+		}
+		return nil, err
+	}
+	for _, name := range []string{ScriptOnConnect, ScriptOnInput, ScriptOnOutput, ScriptOnClose} {
+		if fn, ok := globals[name]; ok {
+			if _, ok := fn.(starlark.Callable); !ok {
+				return nil, fmt.Errorf("%s: %s is not a function", path, name)
+			}
+		}
+	}
+	return &Script{path: path, globals: globals}, nil
+}
+
+// has reports whether the script defines the handler name
+func (sc *Script) has(name string) bool {
+	return sc != nil && sc.globals[name] != nil
+}
+
+// call runs the handler name, if the script defines it, with sess and, for
+// input and output handlers, data
+func (sc *Script) call(name string, sess scriptSession, data []byte, logger zerolog.Logger) (scriptActions, error) {
+	var actions scriptActions
+	if !sc.has(name) {
+		return actions, nil
+	}
+
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			logger.Info().Str("script", sc.path).Str("session", sess.id).Msg(msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	thread.SetLocal(scriptCallKey, &actions)
+
+	tags := starlark.NewDict(len(sess.tags))
+	for name, value := range sess.tags {
+		tags.SetKey(starlark.String(name), starlark.String(value))
+	}
+	args := starlark.Tuple{starlarkstruct.FromStringDict(starlark.String("session"), starlark.StringDict{
+		"id":        starlark.String(sess.id),
+		"path":      starlark.String(sess.path),
+		"client_ip": starlark.String(sess.clientIP),
+		"identity":  starlark.String(sess.identity),
+		"tags":      tags,
+	})}
+	if name == ScriptOnInput || name == ScriptOnOutput {
+		args = append(args, starlark.String(data))
+	}
+
+	if _, err := starlark.Call(thread, sc.globals[name], args, nil); err != nil {
+		return scriptActions{}, fmt.Errorf("%s: %s: %w", sc.path, name, err)
+	}
+	return actions, nil
+}
+
+// scriptBuiltins are the actions available to scripts
+var scriptBuiltins = starlark.StringDict{
+	"deny": starlark.NewBuiltin("deny", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var reason string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "reason?", &reason); err != nil {
+			return nil, err
+		}
+		actions, err := scriptCallActions(thread, fn)
+		if err != nil {
+			return nil, err
+		}
+		if thread.Name != ScriptOnConnect && thread.Name != ScriptOnInput {
+			return nil, fmt.Errorf("%s: only possible in %s and %s", fn.Name(), ScriptOnConnect, ScriptOnInput)
+		}
+		actions.denied, actions.reason = true, reason
+		return starlark.None, nil
+	}),
+	"annotate": starlark.NewBuiltin("annotate", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name, value string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+			return nil, err
+		}
+		if !validTag(name, value) {
+			return nil, fmt.Errorf("%s: invalid tag %q", fn.Name(), name)
+		}
+		actions, err := scriptCallActions(thread, fn)
+		if err != nil {
+			return nil, err
+		}
+		if actions.tags == nil {
+			actions.tags = make(map[string]string)
+		}
+		actions.tags[name] = value
+		return starlark.None, nil
+	}),
+	"notify": starlark.NewBuiltin("notify", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var text string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "text", &text); err != nil {
+			return nil, err
+		}
+		actions, err := scriptCallActions(thread, fn)
+		if err != nil {
+			return nil, err
+		}
+		actions.notices = append(actions.notices, text)
+		return starlark.None, nil
+	}),
+}
+
+// scriptCallActions returns the actions of the handler call running on
+// thread, failing when fn is called while the script loads
+func scriptCallActions(thread *starlark.Thread, fn *starlark.Builtin) (*scriptActions, error) {
+	actions, ok := thread.Local(scriptCallKey).(*scriptActions)
+	if !ok {
+		return nil, fmt.Errorf("%s: only possible in handlers", fn.Name())
+	}
+	return actions, nil
+}
+
+// SetScript runs script's handlers on the server's terminal sessions
+func (s *Server) SetScript(script *Script) {
+	s.script = script
+}
+
+// runScript calls the script handler name for sess, logging failures
+func (s *Server) runScript(name string, sess scriptSession, data []byte) (scriptActions, error) {
+	actions, err := s.script.call(name, sess, data, s.logger)
+	if err != nil {
+		s.stats.countError("script")
+		s.logger.Error().Str("session", sess.id).Err(err).Msg("Script handler failed")
+	}
+	return actions, err
+}
+
+// sessionScriptInfo returns what handlers are told about sess
+func (s *Server) sessionScriptInfo(sess *session) scriptSession {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	return scriptSession{
+		id:       sess.id,
+		path:     sess.endpoint.Path,
+		clientIP: sess.clientIP,
+		identity: sess.identity,
+		tags:     sess.hello.Tags,
+	}
+}
+
+// applyScript carries out the tags and notices a handler asked for on sess
+func (s *Server) applyScript(sess *session, actions scriptActions) {
+	if len(actions.tags) > 0 {
+		s.sessionsMu.Lock()
+		// Replaced rather than changed, listings may still hold the old map
+		tags := maps.Clone(sess.hello.Tags)
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		maps.Copy(tags, actions.tags)
+		sess.hello.Tags = tags
+		s.sessionsMu.Unlock()
+	}
+	for _, text := range actions.notices {
+		sess.notify(text)
+	}
+}
+
+// scriptConnect runs on_connect for a client connecting on conn, returning
+// false if the script denied it. Tags it adds are added to hello, as the
+// session may not exist yet.
+func (s *Server) scriptConnect(conn *safeConn, notices bool, info scriptSession, hello *Hello) bool {
+	actions, err := s.runScript(ScriptOnConnect, info, nil)
+	if err != nil {
+		// A broken policy script must not let everyone in
+		actions = scriptActions{denied: true, reason: "Connection refused by server script"}
+	}
+	if actions.denied {
+		reason := actions.reason
+		if reason == "" {
+			reason = "Connection refused by server script"
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, closeReason(reason)))
+		return false
+	}
+	if len(actions.tags) > 0 {
+		tags := maps.Clone(info.tags)
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		maps.Copy(tags, actions.tags)
+		hello.Tags = tags
+	}
+	for _, text := range actions.notices {
+		sendNotice(conn, notices, text)
+	}
+	return true
+}
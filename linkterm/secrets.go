@@ -0,0 +1,125 @@
+package linkterm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ResolveSecret returns the value a secret reference points to, so secrets
+// need not appear on the command line where other users can read them:
+//
+//	env:NAME           the environment variable NAME
+//	file:PATH          the contents of PATH, without a trailing newline
+//	vault:PATH#FIELD   FIELD of the Vault secret at PATH, using VAULT_ADDR
+//	                   and VAULT_TOKEN or ~/.vault-token
+//	cred:NAME          the credential NAME in the encrypted credential store
+//
+// Any other value is returned as is.
+func ResolveSecret(ctx context.Context, ref string) (string, error) {
+	scheme, rest, found := strings.Cut(ref, ":")
+	if !found {
+		return ref, nil
+	}
+
+	switch scheme {
+	case "env":
+		value, ok := os.LookupEnv(rest)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", rest)
+		}
+		return value, nil
+	case "file":
+		data, err := os.ReadFile(rest)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "vault":
+		return readVaultSecret(ctx, rest)
+	case "cred":
+		store, err := openDefaultCredentialStore()
+		if err != nil {
+			return "", fmt.Errorf("failed to open credential store: %w", err)
+		}
+		value, ok := store.Get(rest)
+		if !ok {
+			return "", fmt.Errorf("no credential named %s, add it with: linkterm credentials set %s", rest, rest)
+		}
+		return value, nil
+	}
+	return ref, nil
+}
+
+// readVaultSecret reads a field of a secret from Vault's HTTP API, for both
+// KV version 1 and 2 engines
+func readVaultSecret(ctx context.Context, ref string) (string, error) {
+	path, field, found := strings.Cut(ref, "#")
+	if !found || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q must be PATH#FIELD", ref)
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(data))
+			}
+		}
+	}
+	if token == "" {
+		return "", errors.New("VAULT_TOKEN is not set and ~/.vault-token is missing")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	url := strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned HTTP %d for %s", resp.StatusCode, path)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	data := body.Data
+	// KV version 2 nests the secret under data.data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, isV2 := data["metadata"]; isV2 {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %s", path, field)
+	}
+	return value, nil
+}
@@ -1,28 +1,31 @@
 package linkterm
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"os"
-	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/creack/pty"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections
-	},
-}
+// DefaultPath is the HTTP path the terminal endpoint is served on
+const DefaultPath = "/terminal"
+
+// DefaultMaxMessageSize bounds the size of a single WebSocket message a peer
+// may send, so it cannot make us buffer arbitrarily large frames
+const DefaultMaxMessageSize = 1 << 20
 
 // Server represents a terminal server
 type Server struct {
@@ -30,7 +33,219 @@ type Server struct {
 	Host      string
 	ShellPath string
 	ShellArgs []string
-	logger    zerolog.Logger
+
+	// Path is the HTTP path of the terminal endpoint, defaults to
+	// DefaultPath under Prefix
+	Path string
+
+	// Prefix is put in front of the paths of the endpoints the server
+	// serves itself, such as /stats and /session/{id}/watch, to keep them
+	// all under a secret path. Endpoints added keep their paths.
+	Prefix string
+
+	// MOTD is written to each client after it attaches, before any shell output
+	MOTD string
+
+	// IdleTimeout ends sessions without client input for this long, 0 disables it
+	IdleTimeout time.Duration
+
+	// MaxDuration ends sessions that have lasted this long, 0 disables it
+	MaxDuration time.Duration
+
+	// WarnBefore is how long before an idle or duration limit the client is warned
+	WarnBefore time.Duration
+
+	// OnDisconnect controls what happens to a shell when its client goes away,
+	// defaults to DisconnectKill
+	OnDisconnect DisconnectPolicy
+
+	// SingleClient limits each authenticated identity to one attached
+	// client, rejecting new connections or letting them take over. Clients
+	// without an identity are not limited. Defaults to SingleClientOff.
+	SingleClient SingleClientPolicy
+
+	// KillSignal is sent to terminate a shell, defaults to SIGTERM
+	KillSignal syscall.Signal
+
+	// KillGrace is how long a terminated shell may take to exit before it is
+	// killed with SIGKILL
+	KillGrace time.Duration
+
+	// KillGroup signals the shell's whole process group instead of the shell
+	// only, so its children are stopped too (Unix only)
+	KillGroup bool
+
+	// ExitAfterIdle stops the server once no session has existed for this
+	// long, 0 disables it
+	ExitAfterIdle time.Duration
+
+	// Once makes the server accept a single terminal session and stop
+	// once that session has ended
+	Once bool
+
+	// InputRate caps the input accepted from each client in bytes per
+	// second, 0 disables it. Input over the limit is discarded, so a runaway
+	// paste cannot flood the shell and Ctrl-C still gets through.
+	InputRate int
+
+	// InputBurst is how many bytes a client may send at once before
+	// InputRate applies, at least InputRate
+	InputBurst int
+
+	// Watch serves /session/{id}/watch, where viewers passing the server's
+	// authentication and holding a session's watch token can follow its
+	// output read-only
+	Watch bool
+
+	// History keeps the command lines typed into each session, with their
+	// exit status from shells that mark commands with OSC 133, serving them
+	// at /session/ID/history to the identity that started the session and
+	// to Admins
+	History bool
+
+	// HistoryDir, with History, is where the history of each session is
+	// written as ID.json when it ends, so it can still be read after
+	HistoryDir string
+
+	// MaxMessageSize is the largest WebSocket message accepted from a client,
+	// defaults to DefaultMaxMessageSize
+	MaxMessageSize int64
+
+	// ReadHeaderTimeout bounds how long a client may take to send its
+	// request headers, so slow clients cannot hold connections open
+	ReadHeaderTimeout time.Duration
+
+	// HTTPIdleTimeout closes kept-alive HTTP connections with no request
+	// for this long. Upgraded terminal connections are not affected.
+	HTTPIdleTimeout time.Duration
+
+	// MaxHeaderBytes bounds the size of request headers
+	MaxHeaderBytes int
+
+	// ReadBufferSize and WriteBufferSize size the I/O buffers of WebSocket
+	// connections, 0 uses the library default of 4 KiB
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// WriteBufferPool shares write buffers between connections, so idle
+	// sessions do not hold one each. A *sync.Pool will do.
+	WriteBufferPool websocket.BufferPool
+
+	// ServeStats serves Stats as JSON at /stats and the session listing at
+	// /sessions, to admins
+	ServeStats bool
+
+	// Admins are the identities allowed to use the admin endpoints. Without
+	// any, those are left to the Authorizer, and closed if there is none.
+	Admins []string
+
+	// ServeEvents streams session starts, ends and rejections as
+	// newline-delimited JSON at /events, to admins
+	ServeEvents bool
+
+	// Forward relays TCP connections at ForwardPath to the host:port clients
+	// name, for clients using the server as a jump host. ForwardAllow limits
+	// the targets to those matching one of its host:port patterns, such as
+	// "*.internal:8080" or "10.0.0.0/8:22", as ForwardPolicy matches them.
+	Forward      bool
+	ForwardAllow []string
+
+	// ForwardPolicy, if set, also has to allow forward targets, which it
+	// refuses by default
+	ForwardPolicy *ForwardPolicy
+
+	// RemoteForward lets clients listen on the server's loopback addresses
+	// at ListenPath, handing them the connections accepted
+	RemoteForward bool
+
+	// GPGAgentForward lets clients forward their gpg-agent, the server
+	// listening on its own agent's socket at GPGAgentPath while they are
+	// connected
+	GPGAgentForward bool
+
+	// AcceptEnv are patterns of the environment variables clients may send
+	// for their shell, such as "GIT_AUTHOR_*". Others are ignored, and none
+	// are accepted if it is empty.
+	AcceptEnv []string
+
+	// Clipboard lets clients push to and pull from the clipboard of the
+	// desktop the server runs in at ClipboardPath
+	Clipboard bool
+
+	// Exec runs commands clients name at ExecPath through the shell without
+	// a terminal, for stdio protocols such as rsync's
+	Exec bool
+
+	// HostKey signs terminal and forward handshakes, so clients can pin the
+	// server's identity. NextHostKey is announced to them ahead of a key
+	// rotation.
+	HostKey     ed25519.PrivateKey
+	NextHostKey ed25519.PublicKey
+
+	// TLSMinVersion is the oldest TLS version StartTLS and StartACME accept,
+	// TLS 1.2 if zero. TLSCipherSuites are the TLS 1.2 cipher suites they
+	// offer, DefaultTLSCipherSuites if nil, and TLSCurves the key exchanges
+	// in order of preference, Go's if nil.
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+	TLSCurves       []tls.CurveID
+
+	// Backend starts the programs behind sessions, defaults to PTYBackend
+	Backend Backend
+
+	// Echo serves EchoPath, which sends every message back, for measuring
+	// the path to the server with `linkterm bench`
+	Echo bool
+
+	logger     zerolog.Logger
+	httpServer *http.Server
+	claimed    atomic.Bool
+	endpoints  []Endpoint
+	approver   Approver
+	audit      *AuditLog
+
+	authenticators []Authenticator
+	authorizer     Authorizer
+	script         *Script
+
+	// pending holds connections accepted for remote forwards until their
+	// clients take them
+	pendingMu sync.Mutex
+	pending   map[string]*pendingConn
+
+	sessionsMu sync.Mutex
+	sessions   map[string]*session
+	idleSince  time.Time
+
+	stats  serverStats
+	events eventHub
+}
+
+// ApprovalRequest describes a connection waiting to be approved
+type ApprovalRequest struct {
+	ClientIP  string
+	UserAgent string
+	Path      string
+}
+
+// Approver decides whether a connection may start a terminal session. It may
+// block, e.g. while asking an operator, and should give up when ctx is done.
+type Approver func(ctx context.Context, req ApprovalRequest) bool
+
+// Endpoint describes an additional terminal served on its own path
+type Endpoint struct {
+	Path      string
+	ShellPath string
+	ShellArgs []string
+
+	// ReadOnly discards input from clients, resize requests are still honored
+	ReadOnly bool
+
+	// Env are variables added to the shell's environment as NAME=VALUE.
+	// Sessions also get TERM and those a client sent that the server
+	// accepts after them, then LINKTERM_SESSION_ID, LINKTERM_CLIENT_IP and
+	// LINKTERM_CLIENT_UA.
+	Env []string
 }
 
 // NewServer creates a new terminal server with the specified port
@@ -44,11 +259,20 @@ func NewServer(port int, host string, shellPath string, shellArgs ...string) *Se
 	}
 
 	return &Server{
-		Port:      port,
-		Host:      host,
-		ShellPath: shellPath,
-		ShellArgs: shellArgs,
-		logger:    zerolog.Nop(), // Default no-op logger
+		Port:              port,
+		Host:              host,
+		ShellPath:         shellPath,
+		ShellArgs:         shellArgs,
+		Path:              DefaultPath,
+		KillSignal:        syscall.SIGTERM,
+		KillGrace:         time.Second,
+		MaxMessageSize:    DefaultMaxMessageSize,
+		ReadHeaderTimeout: 10 * time.Second,
+		HTTPIdleTimeout:   2 * time.Minute,
+		MaxHeaderBytes:    64 << 10,
+		logger:            zerolog.Nop(), // Default no-op logger
+		sessions:          make(map[string]*session),
+		stats:             serverStats{started: time.Now()},
 	}
 }
 
@@ -57,13 +281,173 @@ func (s *Server) SetLogger(logger zerolog.Logger) {
 	s.logger = logger
 }
 
-// Start starts the terminal server
+// SetApprover holds every new connection until approver accepts it
+func (s *Server) SetApprover(approver Approver) {
+	s.approver = approver
+}
+
+// SetAuditLog records session starts, ends and rejections to log
+func (s *Server) SetAuditLog(log *AuditLog) {
+	s.audit = log
+}
+
+// record writes an event to the audit log, if there is one, and the event stream
+func (s *Server) record(event AuditEvent) {
+	event.Time = time.Now().UTC()
+	if err := s.audit.Record(event); err != nil {
+		s.logger.Error().Err(err).Str("event", event.Event).Msg("Failed to write audit log")
+	}
+	s.publish(event)
+}
+
+// AddEndpoint serves an additional terminal with its own command on ep.Path
+func (s *Server) AddEndpoint(ep Endpoint) {
+	s.endpoints = append(s.endpoints, ep)
+}
+
+// Start starts the terminal server and blocks until it is shut down
 func (s *Server) Start() error {
-	http.HandleFunc("/terminal", s.handleTerminal)
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// StartTLS is like Start but serves wss:// with the certificate and key in
+// the PEM files certFile and keyFile
+func (s *Server) StartTLS(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := s.tlsConfig()
+	config.Certificates = []tls.Certificate{cert}
+	// WebSocket upgrades need HTTP/1.1
+	config.NextProtos = []string{"http/1.1"}
+	return s.startTLS(config)
+}
+
+// startTLS listens on the server's address and serves TLS connections
+// configured by config
+func (s *Server) startTLS(config *tls.Config) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return err
+	}
+	return s.Serve(tls.NewListener(listener, config))
+}
+
+// Serve serves terminals on connections accepted from listener and blocks
+// until the server is shut down
+func (s *Server) Serve(listener net.Listener) error {
+	prefix := s.Prefix
+	path := s.Path
+	if path == "" {
+		path = prefix + DefaultPath
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.handleTerminal(Endpoint{
+		Path:      path,
+		ShellPath: s.ShellPath,
+		ShellArgs: s.ShellArgs,
+	}))
+	for _, ep := range s.endpoints {
+		mux.HandleFunc(ep.Path, s.handleTerminal(ep))
+		s.logger.Info().Str("path", ep.Path).Str("shell", ep.ShellPath).Bool("readOnly", ep.ReadOnly).Msg("Added terminal endpoint")
+	}
+	if s.Watch {
+		mux.HandleFunc("GET "+prefix+"/session/{id}/watch", s.handleWatch)
+		mux.HandleFunc("POST "+prefix+"/session/{id}/shares", s.handleShareCreate)
+		mux.HandleFunc("DELETE "+prefix+"/session/{id}/shares", s.handleShareRevoke)
+		mux.HandleFunc("DELETE "+prefix+"/session/{id}/shares/{token}", s.handleShareRevoke)
+	}
+	if s.History {
+		mux.HandleFunc("GET "+prefix+"/session/{id}/history", s.handleHistory)
+	}
+	if s.ServeStats {
+		mux.HandleFunc("GET "+prefix+"/stats", s.handleStats)
+		mux.HandleFunc("GET "+prefix+"/sessions", s.handleSessions)
+	}
+	if s.ServeEvents {
+		mux.HandleFunc("GET "+prefix+"/events", s.handleEvents)
+	}
+	if s.Echo {
+		mux.HandleFunc("GET "+prefix+EchoPath, s.handleEcho)
+	}
+	if s.Forward {
+		mux.HandleFunc("GET "+prefix+ForwardPath, s.handleForward)
+	}
+	if s.RemoteForward {
+		mux.HandleFunc("GET "+prefix+ListenPath, s.handleListen)
+	}
+	if s.GPGAgentForward {
+		mux.HandleFunc("GET "+prefix+GPGAgentPath, s.handleGPGAgent)
+	}
+	if s.Exec {
+		mux.HandleFunc("GET "+prefix+ExecPath, s.handleExec)
+	}
+	if s.Clipboard {
+		mux.HandleFunc("GET "+prefix+ClipboardPath, s.handleClipboard)
+	}
+
+	addr := listener.Addr().String()
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		IdleTimeout:       s.HTTPIdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+	}
+
+	if s.ExitAfterIdle > 0 {
+		s.sessionsMu.Lock()
+		s.idleSince = time.Now()
+		s.sessionsMu.Unlock()
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.exitWhenIdle(stop)
+	}
+
+	s.logger.Info().Str("addr", addr).Str("path", path).Msg("Started WebSocket terminal server")
+	if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// exitWhenIdle shuts the server down once it has had no sessions for ExitAfterIdle
+func (s *Server) exitWhenIdle(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.sessionsMu.Lock()
+			idle := len(s.sessions) == 0 && time.Since(s.idleSince) >= s.ExitAfterIdle
+			s.sessionsMu.Unlock()
+
+			if idle {
+				s.logger.Info().Str("idle", s.ExitAfterIdle.String()).Msg("No sessions for too long, shutting down")
+				s.Shutdown(context.Background())
+				return
+			}
+		}
+	}
+}
 
-	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
-	s.logger.Info().Str("addr", addr).Msg("Started WebSocket terminal server")
-	return http.ListenAndServe(addr, nil)
+// Shutdown gracefully stops the server, causing Start to return
+func (s *Server) Shutdown(ctx context.Context) error {
+	// Event streams would otherwise keep the server from finishing
+	s.events.close()
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
 }
 
 // getClientIP extracts the real client IP from headers or remote address
@@ -95,162 +479,518 @@ func getClientIP(r *http.Request) string {
 	return remoteAddr
 }
 
-// handleTerminal handles the terminal WebSocket connection
-func (s *Server) handleTerminal(w http.ResponseWriter, r *http.Request) {
+// watchSession warns the client ahead of the idle and duration limits and
+// terminates the session once one of them is reached
+func (s *Server) watchSession(sess *session) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	terminate := func(reason string) {
+		s.logger.Info().Str("clientIP", sess.clientIP).Str("session", sess.id).Str("reason", reason).Msg("Terminating session")
+		sess.closeClient(websocket.CloseNormalClosure, reason)
+		s.terminate(sess)
+	}
+
+	idleWarned, maxWarned := false, false
+	for {
+		select {
+		case <-sess.done:
+			return
+		case now := <-ticker.C:
+			if s.MaxDuration > 0 {
+				remaining := time.Until(sess.startTime.Add(s.MaxDuration))
+				if remaining <= 0 {
+					terminate("Maximum session duration reached")
+					return
+				}
+				if !maxWarned && remaining <= s.WarnBefore {
+					maxWarned = true
+					sess.notify(fmt.Sprintf("Session reaches its maximum duration in %s", remaining.Round(time.Second)))
+				}
+			}
+
+			if s.IdleTimeout > 0 {
+				idle := now.Sub(time.Unix(0, sess.lastInput.Load()))
+				remaining := s.IdleTimeout - idle
+				if remaining <= 0 {
+					terminate("Idle timeout")
+					return
+				}
+				if remaining > s.WarnBefore {
+					// Input arrived since the last warning, warn again next time
+					idleWarned = false
+				} else if !idleWarned {
+					idleWarned = true
+					sess.notify(fmt.Sprintf("Session is idle and ends in %s, press any key to extend", remaining.Round(time.Second)))
+				}
+			}
+		}
+	}
+}
+
+// attachedSessions returns the sessions of identity with a client attached
+func (s *Server) attachedSessions(identity string) []*session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	var attached []*session
+	for _, sess := range s.sessions {
+		if sess.identity == identity && !sess.detached && sess.attached() {
+			attached = append(attached, sess)
+		}
+	}
+	return attached
+}
+
+// takeDetached claims the detached session id for a reattaching client
+func (s *Server) takeDetached(id string) *session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sess := s.sessions[id]
+	if sess == nil || !sess.detached {
+		return nil
+	}
+	sess.detached = false
+	return sess
+}
+
+// park marks sess as detached so a client can reattach to it later
+func (s *Server) park(sess *session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	sess.detached = true
+}
+
+// toCRLF converts bare line feeds to CRLF, as the client terminal is in raw mode
+func toCRLF(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\n", "\r\n")
+}
+
+// upgrade switches r to a WebSocket connection with the server's buffer
+// settings, sending header with the handshake response
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (*websocket.Conn, error) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  s.ReadBufferSize,
+		WriteBufferSize: s.WriteBufferSize,
+		WriteBufferPool: s.WriteBufferPool,
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Allow all connections
+		},
+	}
+	return upgrader.Upgrade(w, r, header)
+}
+
+// handleTerminal returns the handler serving terminal WebSocket connections for ep
+func (s *Server) handleTerminal(ep Endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveTerminal(w, r, ep)
+	}
+}
+
+// serveTerminal handles a terminal WebSocket connection on endpoint ep, either
+// starting a new session or reattaching to a kept one
+func (s *Server) serveTerminal(w http.ResponseWriter, r *http.Request, ep Endpoint) {
 	// Get the client IP for logging
 	clientIP := getClientIP(r)
 	userAgent := r.UserAgent()
 	if userAgent == "" {
 		userAgent = "Unknown"
 	}
+	s.stats.countClient(clientIP)
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	identity, err := s.authenticate(r)
 	if err != nil {
-		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		s.stats.countError("auth_failed")
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected connection, authentication failed")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Detail: err.Error()})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, ep.Path) {
 		return
 	}
-	defer conn.Close()
 
-	// Record connection start time
-	startTime := time.Now()
-	s.logger.Info().Str("clientIP", clientIP).Str("userAgent", userAgent).Msg("Client connected")
+	// The identity's attached sessions, taken over once the connection is upgraded
+	var busy []*session
+	if identity != "" && (s.SingleClient == SingleClientReject || s.SingleClient == SingleClientTakeover) {
+		busy = s.attachedSessions(identity)
+	}
+	if len(busy) > 0 && s.SingleClient == SingleClientReject {
+		s.logger.Warn().Str("clientIP", clientIP).Str("identity", identity).Msg("Rejected connection, identity already has a client attached")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity, Detail: "identity already has a client attached"})
+		s.stats.countError("identity_busy")
+		http.Error(w, "Another client is attached for this identity", http.StatusConflict)
+		return
+	}
 
-	// Create a new command
-	cmd := exec.Command(s.ShellPath, s.ShellArgs...)
-	cmd.Env = os.Environ()
+	var sess *session
+	if id := r.URL.Query().Get("session"); id != "" {
+		if sess = s.takeDetached(id); sess == nil {
+			s.logger.Warn().Str("clientIP", clientIP).Str("session", id).Msg("Rejected reattach to unknown session")
+			s.record(AuditEvent{Event: "connection_rejected", Session: id, ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Detail: "unknown session"})
+			s.stats.countError("unknown_session")
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if sess.identity != identity {
+			// Sessions can only be taken over by whoever started them
+			s.park(sess)
+			s.logger.Warn().Str("clientIP", clientIP).Str("session", id).Str("identity", identity).Msg("Rejected reattach by a different identity")
+			s.record(AuditEvent{Event: "connection_rejected", Session: id, ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity, Detail: "session belongs to another identity"})
+			s.stats.countError("reattach_forbidden")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	} else if s.Once && !s.claimed.CompareAndSwap(false, true) {
+		// In single-session mode only the first client gets a terminal
+		s.logger.Warn().Str("clientIP", clientIP).Msg("Rejected connection, session already taken")
+		s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Detail: "session already taken"})
+		s.stats.countError("session_taken")
+		http.Error(w, "Session already taken", http.StatusServiceUnavailable)
+		return
+	}
 
-	// Start the command with a pty
-	ptmx, err := pty.Start(cmd)
+	// Agree to the optional features the client asked for that we support
+	agreed := parseFeatures(r.Header).agree(serverFeatures)
+	header := make(http.Header)
+	if len(agreed) > 0 {
+		header.Set(FeaturesHeader, strings.Join(agreed, ", "))
+	}
+	if s.HostKey != nil {
+		s.signHandshake(r, header)
+	}
+	wsConn, err := s.upgrade(w, r, header)
 	if err != nil {
-		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error starting pty")
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		s.stats.countError("upgrade")
+		if sess != nil {
+			s.park(sess)
+		} else if s.Once {
+			// A failed upgrade does not count as the single session
+			s.claimed.Store(false)
+		}
 		return
 	}
+	conn := newSafeConn(wsConn)
+	defer conn.Close()
+	if s.MaxMessageSize > 0 {
+		conn.SetReadLimit(s.MaxMessageSize)
+	}
+
+	// Clients agreeing to hellos send theirs before anything else
+	var hello Hello
+	if slices.Contains(agreed, FeatureHello) {
+		if hello, err = readHello(conn); err == nil {
+			hello.Tags = acceptedTags(hello.Tags)
+			err = writeHello(conn, Hello{Version: Version, Platform: Platform, Features: serverFeatures})
+		}
+		if err != nil {
+			s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Client failed to say hello")
+			s.stats.countError("hello")
+			if sess != nil {
+				s.park(sess)
+			} else if s.Once {
+				s.claimed.Store(false)
+			}
+			return
+		}
+	}
 
-	// Create a clean shutdown function
-	closeSession := func() {
-		ptmx.Close()
-		// Send terminal process termination signal
-		if cmd.Process != nil {
-			cmd.Process.Signal(syscall.SIGTERM)
-			// Wait for process to exit or force kill after a brief period
-			done := make(chan struct{})
-			go func() {
-				cmd.Wait()
-				close(done)
-			}()
-
-			select {
-			case <-done:
-				// Process exited cleanly
-			case <-time.After(time.Second):
-				// Force kill if it doesn't respond
-				cmd.Process.Kill()
+	if s.script.has(ScriptOnConnect) {
+		info := scriptSession{path: ep.Path, clientIP: clientIP, identity: identity, tags: hello.Tags}
+		if sess != nil {
+			info.id = sess.id
+			if info.tags == nil {
+				info.tags = s.sessionScriptInfo(sess).tags
+			}
+		}
+		if !s.scriptConnect(conn, slices.Contains(agreed, FeatureNotices), info, &hello) {
+			s.logger.Info().Str("clientIP", clientIP).Str("identity", identity).Msg("Connection denied by script")
+			s.record(AuditEvent{Event: "connection_rejected", Session: info.id, ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity, Detail: "denied by script"})
+			s.stats.countError("script_denied")
+			if sess != nil {
+				s.park(sess)
+			} else if s.Once {
+				s.claimed.Store(false)
 			}
+			return
+		}
+	}
+
+	s.logger.Info().Str("clientIP", clientIP).Str("userAgent", userAgent).Str("path", ep.Path).Str("identity", identity).Msg("Client connected")
+
+	for _, old := range busy {
+		s.logger.Info().Str("clientIP", clientIP).Str("session", old.id).Str("identity", identity).Msg("Client took over from the attached one")
+		s.record(AuditEvent{Event: "session_takeover", Session: old.id, ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity})
+		old.notify("Another client connected as " + identity + " from " + clientIP)
+		old.closeClient(websocket.CloseNormalClosure, "Taken over by another client")
+	}
+
+	if sess == nil {
+		if sess = s.newSession(r, conn, agreed, hello, ep, clientIP, userAgent, identity); sess == nil {
+			return
 		}
+	} else {
+		s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Msg("Client reattached")
+		s.record(AuditEvent{Event: "session_reattach", Session: sess.id, ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity})
+		s.sessionsMu.Lock()
+		sess.clientIP = clientIP
+		if hello.Tags == nil {
+			// Tags stay with the session unless the new client brings its own
+			hello.Tags = sess.hello.Tags
+		}
+		sess.hello = hello
+		s.sessionsMu.Unlock()
+		sess.resize(hello)
+	}
 
-		// Calculate session duration
-		duration := time.Since(startTime)
-		hours := int(duration.Hours())
-		minutes := int(duration.Minutes()) % 60
-		seconds := int(duration.Seconds()) % 60
-
-		// Format duration string
-		var durationStr string
-		if hours > 0 {
-			durationStr = fmt.Sprintf("%d hours, %d minutes, %d seconds", hours, minutes, seconds)
-		} else if minutes > 0 {
-			durationStr = fmt.Sprintf("%d minutes, %d seconds", minutes, seconds)
+	if err := sess.attach(conn, agreed); err != nil {
+		if errors.Is(err, ErrSessionClosed) {
+			s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Msg("Session ended before the client attached")
 		} else {
-			durationStr = fmt.Sprintf("%d seconds", seconds)
+			s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error writing to WebSocket client")
 		}
+		s.release(sess, conn)
+		return
+	}
+	if s.Watch && r.URL.Query().Get("session") == "" {
+		sess.notify("Others can watch this session read-only at " + s.watchURL(r, sess.id, sess.watchToken))
+	}
+	if s.OnDisconnect == DisconnectKeep {
+		sess.notify(fmt.Sprintf("Session %s keeps running if you disconnect, reattach with --attach %s", sess.id, sess.id))
+	}
 
-		s.logger.Info().Str("clientIP", clientIP).Str("duration", durationStr).Msg("Session ended")
+	s.pumpInput(conn, sess, slices.Contains(agreed, FeatureResizeAck))
+	s.release(sess, conn)
+}
+
+// newSession runs the approval and greeting steps for a new client and starts
+// its shell, returning nil if the client should be turned away
+func (s *Server) newSession(r *http.Request, conn *safeConn, agreed []string, hello Hello, ep Endpoint, clientIP, userAgent, identity string) *session {
+	if s.approver != nil {
+		if slices.Contains(agreed, FeatureNotices) {
+			sendNotice(conn, true, "Waiting for approval from the server operator...")
+		} else {
+			conn.WriteMessage(websocket.BinaryMessage, []byte("Waiting for approval from the server operator...\r\n"))
+		}
+		approved := s.approver(r.Context(), ApprovalRequest{
+			ClientIP:  clientIP,
+			UserAgent: userAgent,
+			Path:      ep.Path,
+		})
+		if !approved {
+			s.logger.Info().Str("clientIP", clientIP).Msg("Connection rejected by operator")
+			s.record(AuditEvent{Event: "connection_rejected", ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity, Detail: "rejected by operator"})
+			s.stats.countError("operator_rejected")
+			closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Connection rejected by server operator")
+			conn.WriteMessage(websocket.CloseMessage, closeMsg)
+			if s.Once {
+				// A rejected connection does not count as the single session
+				s.claimed.Store(false)
+			}
+			return nil
+		}
+		s.logger.Info().Str("clientIP", clientIP).Msg("Connection approved by operator")
 	}
-	defer closeSession()
 
-	// Channel to coordinate goroutine termination
-	done := make(chan struct{})
-	defer close(done)
+	if s.MOTD != "" {
+		conn.WriteMessage(websocket.BinaryMessage, []byte(toCRLF(s.MOTD)))
+	}
 
-	// Set up error handling that doesn't spam the logs
-	isClosing := false
+	backend := s.Backend
+	if backend == nil {
+		backend = PTYBackend{}
+	}
+	// The terminal type from the hello can be overridden by accepted variables
+	env := append([]string(nil), ep.Env...)
+	if validTerm(hello.Term) {
+		env = append(env, "TERM="+hello.Term)
+	}
+	// Set last, so a client cannot pass itself off as another
+	ep.Env = append(append(env, s.acceptedEnv(r)...), "LINKTERM_CLIENT_IP="+clientIP, "LINKTERM_CLIENT_UA="+userAgent)
+	var history *commandHistory
+	if s.History {
+		history = &commandHistory{}
+	}
+	var onOutput func(*session, []byte)
+	if s.script.has(ScriptOnOutput) || history != nil {
+		onOutput = func(sess *session, data []byte) {
+			if history != nil {
+				history.output(data)
+			}
+			if s.script.has(ScriptOnOutput) {
+				actions, _ := s.runScript(ScriptOnOutput, s.sessionScriptInfo(sess), data)
+				s.applyScript(sess, actions)
+			}
+		}
+	}
+	sess, err := startSession(backend, ep, clientIP, s.logger, onOutput, func(sess *session) {
+		if s.script.has(ScriptOnClose) {
+			actions, _ := s.runScript(ScriptOnClose, s.sessionScriptInfo(sess), nil)
+			s.applyScript(sess, actions)
+		}
+		s.record(AuditEvent{Event: "session_end", Session: sess.id, ClientIP: sess.clientIP, Path: ep.Path, Identity: identity,
+			Detail: formatDuration(time.Since(sess.startTime))})
+		// Saved before the session goes, so its history never disappears
+		if err := s.saveHistory(sess); err != nil {
+			s.logger.Error().Str("session", sess.id).Err(err).Msg("Failed to save session history")
+		}
 
-	// Handle terminal resize and input
-	go func() {
-		for {
-			messageType, p, err := conn.ReadMessage()
-			if err != nil {
-				if !isClosing {
-					if websocket.IsUnexpectedCloseError(err) {
-						s.logger.Info().Str("clientIP", clientIP).Msg("Client disconnected unexpectedly")
-					} else if !strings.Contains(err.Error(), "use of closed") {
-						s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error reading from client")
-					}
-					isClosing = true
+		s.sessionsMu.Lock()
+		// Moved to the totals together, so Stats never counts them twice
+		s.stats.bytesIn.Add(sess.bytesIn.Load())
+		s.stats.bytesOut.Add(sess.bytesOut.Load())
+		delete(s.sessions, sess.id)
+		if len(s.sessions) == 0 {
+			s.idleSince = time.Now()
+		}
+		s.sessionsMu.Unlock()
+
+		if s.Once {
+			// Stop serving after the single session
+			go s.Shutdown(context.Background())
+		}
+	})
+	if err != nil {
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error starting pty")
+		s.stats.countError("pty")
+		// The client adds that this is a pty error itself
+		reason := "failed to start shell: " + strings.TrimPrefix(err.Error(), ErrPTY.Error()+": ")
+		closeMsg := websocket.FormatCloseMessage(CloseShellFailed, closeReason(reason))
+		conn.WriteMessage(websocket.CloseMessage, closeMsg)
+		if s.Once {
+			// A shell that never started does not count as the single session
+			s.claimed.Store(false)
+		}
+		return nil
+	}
+
+	// Script handlers may already be reading them from the output loop
+	s.sessionsMu.Lock()
+	sess.identity = identity
+	sess.hello = hello
+	s.sessionsMu.Unlock()
+	sess.resize(hello)
+	s.stats.totalSessions.Add(1)
+
+	s.sessionsMu.Lock()
+	sess.history = history
+	s.sessions[sess.id] = sess
+	s.sessionsMu.Unlock()
+	s.record(AuditEvent{Event: "session_start", Session: sess.id, ClientIP: clientIP, UserAgent: userAgent, Path: ep.Path, Identity: identity})
+
+	if s.IdleTimeout > 0 || s.MaxDuration > 0 {
+		go s.watchSession(sess)
+	}
+	return sess
+}
+
+// pumpInput feeds input and resize requests from conn to the session until the
+// client goes away or the session ends. With ackResize every resize request
+// is answered with whether it succeeded.
+func (s *Server) pumpInput(conn *safeConn, sess *session, ackResize bool) {
+	limiter := newRateLimiter(s.InputRate, s.InputBurst)
+	var lastDropNotice time.Time
+
+	for {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			if !sess.closing.Load() {
+				if errors.Is(err, websocket.ErrReadLimit) {
+					s.stats.countError("message_too_large")
+					s.logger.Warn().Str("clientIP", sess.clientIP).Int64("limit", s.MaxMessageSize).Msg("Client sent a message over the size limit")
+				} else if websocket.IsUnexpectedCloseError(err) {
+					s.logger.Info().Str("clientIP", sess.clientIP).Msg("Client disconnected unexpectedly")
+				} else if !isClosedErr(err) {
+					s.stats.countError("read")
+					s.logger.Error().Str("clientIP", sess.clientIP).Err(err).Msg("Error reading from client")
 				}
-				return
 			}
+			return
+		}
+		sess.bytesIn.Add(int64(len(p)))
+		sess.framesIn.Add(1)
+
+		if messageType == websocket.TextMessage {
+			// Message format: "resize:cols:rows"
+			if len(p) > 7 && string(p[0:7]) == "resize:" {
+				if size, ok := parseResize(p); ok {
+					err := sess.proc.Resize(size.cols, size.rows)
+					if err != nil {
+						s.logger.Error().Err(err).Msg("Error resizing pty")
+					} else {
+						sess.size.Store(size)
+					}
+					if ackResize {
+						conn.WriteMessage(websocket.TextMessage, formatResizeAck(size, err == nil))
+					}
+				}
+			} else {
+				sess.lastInput.Store(time.Now().UnixNano())
+				if sess.endpoint.ReadOnly {
+					continue
+				}
 
-			if messageType == websocket.TextMessage {
-				// Message format: "resize:cols:rows"
-				if len(p) > 7 && string(p[0:7]) == "resize:" {
-					parts := strings.Split(string(p[7:]), ":")
-					if len(parts) == 2 {
-						cols, err1 := strconv.Atoi(parts[0])
-						rows, err2 := strconv.Atoi(parts[1])
-
-						if err1 == nil && err2 == nil && cols > 0 && rows > 0 {
-							if err := pty.Setsize(ptmx, &pty.Winsize{
-								Cols: uint16(cols),
-								Rows: uint16(rows),
-							}); err != nil {
-								s.logger.Error().Err(err).Msg("Error resizing pty")
-							}
-						}
+				if n := limiter.allow(len(p)); n < len(p) {
+					if time.Since(lastDropNotice) > time.Second {
+						lastDropNotice = time.Now()
+						s.logger.Warn().Str("clientIP", sess.clientIP).Str("session", sess.id).Int("dropped", len(p)-n).Msg("Input rate limit exceeded")
+						sess.notify("Input rate limit exceeded, some input was discarded")
 					}
-				} else {
-					// Write input to the PTY
-					_, _ = ptmx.Write(p)
+					p = p[:n]
 				}
-			}
-		}
-	}()
-
-	// Copy output from the PTY to the WebSocket
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 1024)
-		for {
-			n, err := ptmx.Read(buf)
-			if err != nil {
-				if err != io.EOF && !isClosing && !strings.Contains(err.Error(), "input/output error") {
-					s.logger.Error().Err(err).Msg("Error reading from PTY")
+
+				if s.script.has(ScriptOnInput) {
+					// A failing handler lets input through, rather than locking the session
+					actions, _ := s.runScript(ScriptOnInput, s.sessionScriptInfo(sess), p)
+					s.applyScript(sess, actions)
+					if actions.denied {
+						if actions.reason != "" {
+							sess.notify(actions.reason)
+						}
+						continue
+					}
 				}
-				break
-			}
 
-			err = conn.WriteMessage(websocket.BinaryMessage, buf[:n])
-			if err != nil {
-				if !isClosing && !strings.Contains(err.Error(), "use of closed") {
-					s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error writing to WebSocket client")
+				if sess.history != nil {
+					sess.history.input(p)
 				}
-				isClosing = true
-				break
+				// Write input to the PTY
+				_, _ = sess.proc.Write(p)
 			}
 		}
-	}()
-
-	// Wait for the process to end
-	go func() {
-		cmd.Wait()
-		// Gracefully close the WebSocket connection when the terminal exits
-		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Terminal session ended")
-		// Ignore errors during close, as the connection might already be gone
-		conn.WriteMessage(websocket.CloseMessage, closeMsg)
-		isClosing = true
-	}()
+	}
+}
 
-	wg.Wait()
+// release applies the disconnect policy once conn is no longer serving sess
+func (s *Server) release(sess *session, conn *safeConn) {
+	sess.detach(conn)
+
+	select {
+	case <-sess.done:
+		return
+	default:
+	}
+
+	switch s.OnDisconnect {
+	case DisconnectKeep:
+		s.park(sess)
+		s.logger.Info().Str("clientIP", sess.clientIP).Str("session", sess.id).Msg("Session kept for reattach")
+	case DisconnectSignal:
+		// The output loop keeps draining until the shell decides to exit
+		sess.signal(syscall.SIGHUP, s.KillGroup)
+	default:
+		s.terminate(sess)
+	}
+}
+
+// terminate stops a session's shell according to the server's kill settings
+func (s *Server) terminate(sess *session) {
+	sig := s.KillSignal
+	if sig == 0 {
+		sig = syscall.SIGTERM
+	}
+	sess.terminate(sig, s.KillGrace, s.KillGroup)
 }
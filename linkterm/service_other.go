@@ -0,0 +1,35 @@
+//go:build !windows
+
+package linkterm
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+// errNoServiceManager is returned by service management outside of Windows
+var errNoServiceManager = errors.New("service management is only available on Windows, use --daemon or a process manager instead")
+
+// isWindowsService reports whether we were started by the service control manager
+func isWindowsService() bool {
+	return false
+}
+
+// serviceLogger returns a logger writing to the Windows event log under name
+func serviceLogger(name string) (zerolog.Logger, error) {
+	return zerolog.Nop(), errNoServiceManager
+}
+
+// runService runs start under the service control manager
+func runService(name string, start func() error, stop func()) error {
+	return errNoServiceManager
+}
+
+func installService(name string, args []string) error { return errNoServiceManager }
+
+func startService(name string) error { return errNoServiceManager }
+
+func stopService(name string) error { return errNoServiceManager }
+
+func uninstallService(name string) error { return errNoServiceManager }
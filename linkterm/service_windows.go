@@ -0,0 +1,203 @@
+//go:build windows
+
+package linkterm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// isWindowsService reports whether we were started by the service control manager
+func isWindowsService() bool {
+	inService, err := svc.IsWindowsService()
+	return err == nil && inService
+}
+
+// serviceLogger returns a logger writing to the Windows event log under name
+func serviceLogger(name string) (zerolog.Logger, error) {
+	log, err := eventlog.Open(name)
+	if err != nil {
+		return zerolog.Nop(), fmt.Errorf("failed to open event log: %w", err)
+	}
+	return zerolog.New(eventLogWriter{log: log}).With().Timestamp().Logger(), nil
+}
+
+// eventLogWriter renders zerolog entries as plain text event log records
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.InfoLevel, p)
+}
+
+func (w eventLogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var buf bytes.Buffer
+	console := zerolog.ConsoleWriter{
+		Out:          &buf,
+		NoColor:      true,
+		PartsExclude: []string{zerolog.TimestampFieldName},
+	}
+	if _, err := console.Write(p); err != nil {
+		return 0, err
+	}
+
+	var err error
+	msg := strings.TrimSpace(buf.String())
+	switch {
+	case level >= zerolog.ErrorLevel:
+		err = w.log.Error(1, msg)
+	case level == zerolog.WarnLevel:
+		err = w.log.Warning(1, msg)
+	default:
+		err = w.log.Info(1, msg)
+	}
+	return len(p), err
+}
+
+// runService runs start under the service control manager, calling stop when
+// the service is asked to stop, and returns once start has returned
+func runService(name string, start func() error, stop func()) error {
+	return svc.Run(name, &serviceHandler{start: start, stop: stop})
+}
+
+// serviceHandler adapts the server's start and stop functions to svc.Handler
+type serviceHandler struct {
+	start func() error
+	stop  func()
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.start()
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				// Report a service-specific failure so recovery actions apply
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				h.stop()
+				<-errCh
+				return false, 0
+			}
+		}
+	}
+}
+
+// installService registers a service running `linkterm server args...` at
+// boot, along with an event log source of the same name
+func installService(name string, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	serverArgs := append([]string{"server", "--service-name", name}, args...)
+	s, err := m.CreateService(name, executable, mgr.Config{
+		DisplayName: "LinkTerm (" + name + ")",
+		Description: "Shares a terminal over WebSocket",
+		StartType:   mgr.StartAutomatic,
+	}, serverArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+	return nil
+}
+
+// startService asks the service manager to start the service
+func startService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+// stopService asks the service to stop and waits for it to do so
+func stopService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		status, err := s.Control(svc.Stop)
+		if err != nil {
+			return fmt.Errorf("failed to stop service: %w", err)
+		}
+
+		deadline := time.Now().Add(30 * time.Second)
+		for status.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out waiting for service to stop")
+			}
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				return fmt.Errorf("failed to query service: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// uninstallService removes the service and its event log source
+func uninstallService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if err := s.Delete(); err != nil {
+			return fmt.Errorf("failed to delete service: %w", err)
+		}
+		if err := eventlog.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove event log source: %w", err)
+		}
+		return nil
+	})
+}
+
+// withService opens the named service and calls fn with it
+func withService(name string, fn func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	return fn(s)
+}
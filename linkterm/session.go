@@ -0,0 +1,506 @@
+package linkterm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+)
+
+// maxBacklog bounds the output buffered for a session while no client is attached
+const maxBacklog = 64 * 1024
+
+// DisconnectPolicy controls what happens to a shell when its client goes away
+type DisconnectPolicy string
+
+const (
+	// DisconnectKill terminates the shell, escalating to SIGKILL if needed
+	DisconnectKill DisconnectPolicy = "kill"
+	// DisconnectSignal sends SIGHUP only and lets the shell decide
+	DisconnectSignal DisconnectPolicy = "signal"
+	// DisconnectKeep leaves the shell running so a client can reattach
+	DisconnectKeep DisconnectPolicy = "keep"
+)
+
+// ParseDisconnectPolicy validates a disconnect policy name
+func ParseDisconnectPolicy(name string) (DisconnectPolicy, error) {
+	switch policy := DisconnectPolicy(name); policy {
+	case DisconnectKill, DisconnectSignal, DisconnectKeep:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown disconnect policy %q, expected kill, signal or keep", name)
+}
+
+// SingleClientPolicy controls new connections of an identity that already has
+// a client attached to a session
+type SingleClientPolicy string
+
+const (
+	// SingleClientOff lets an identity attach any number of clients
+	SingleClientOff SingleClientPolicy = "off"
+	// SingleClientReject turns the new connection away
+	SingleClientReject SingleClientPolicy = "reject"
+	// SingleClientTakeover detaches the attached client with a notice and lets
+	// the new connection in
+	SingleClientTakeover SingleClientPolicy = "takeover"
+)
+
+// ParseSingleClientPolicy validates a single client policy name
+func ParseSingleClientPolicy(name string) (SingleClientPolicy, error) {
+	switch policy := SingleClientPolicy(name); policy {
+	case SingleClientOff, SingleClientReject, SingleClientTakeover:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown single client policy %q, expected off, reject or takeover", name)
+}
+
+// session is a shell started by a Backend. Its output is pumped for the whole life
+// of the process, so it can outlive the client connection attached to it.
+type session struct {
+	id       string
+	endpoint Endpoint
+	clientIP string
+	// identity is who the client authenticated as, empty without authentication
+	identity  string
+	startTime time.Time
+	proc      Process
+	logger    zerolog.Logger
+
+	// exited is closed once the process has been reaped, exitCode is then
+	// its exit status or -1 if unknown
+	exited   chan struct{}
+	exitCode int
+	// done is closed once the session has ended
+	done chan struct{}
+
+	lastInput atomic.Int64
+	// lastOutput is when the shell last printed something, in Unix nanoseconds
+	lastOutput atomic.Int64
+	closing    atomic.Bool
+	// bytesIn and bytesOut count input from clients and output of the shell
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	// framesIn and framesOut count WebSocket messages from and to clients
+	framesIn  atomic.Int64
+	framesOut atomic.Int64
+	// size is the termSize clients last resized the terminal to
+	size atomic.Value
+	// hello is what the attached client told about itself, empty for
+	// clients without FeatureHello
+	hello Hello
+
+	// watchToken authorizes read-only viewers of the session
+	watchToken string
+
+	mu       sync.Mutex
+	conn     *safeConn
+	backlog  []byte
+	detached bool
+	// reportExit is set if the attached client wants the exit status
+	reportExit bool
+	// notices is set if the attached client shows notices itself
+	notices bool
+	// lowBandwidth is set if the attached client asked for thinned output,
+	// which gathers in pending until flushTimer sends it
+	lowBandwidth bool
+	pending      []byte
+	flushTimer   *time.Timer
+	// scrollback holds the latest output for viewers joining late
+	scrollback []byte
+	watchers   map[*watcher]struct{}
+	// shares are the tokens of the session's share links, revoked when their
+	// timers fire
+	shares map[string]*time.Timer
+	// history gathers the commands typed, nil unless the server keeps it
+	history *commandHistory
+
+	finishOnce sync.Once
+	onOutput   func(*session, []byte)
+	onFinish   func(*session)
+}
+
+// startSession spawns the endpoint's command with backend. onOutput, if not
+// nil, sees each piece of output before clients do, onFinish is called once
+// the session has ended.
+func startSession(backend Backend, ep Endpoint, clientIP string, logger zerolog.Logger, onOutput func(*session, []byte), onFinish func(*session)) (*session, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	watchToken, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// The session's ID is only known here, tell the shell too
+	ep.Env = append(slices.Clip(ep.Env), "LINKTERM_SESSION_ID="+id)
+	proc, err := backend.Start(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &session{
+		id:         id,
+		watchToken: watchToken,
+		endpoint:   ep,
+		clientIP:   clientIP,
+		startTime:  time.Now(),
+		proc:       proc,
+		logger:     logger,
+		exited:     make(chan struct{}),
+		done:       make(chan struct{}),
+		onOutput:   onOutput,
+		onFinish:   onFinish,
+	}
+	sess.lastInput.Store(time.Now().UnixNano())
+
+	// Reap the process in a single place, everyone else waits on exited
+	go func() {
+		sess.exitCode = exitStatus(proc.Wait())
+		close(sess.exited)
+	}()
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		sess.pumpOutput()
+	}()
+
+	// Wait for the process to end
+	go func() {
+		<-sess.exited
+		// Give the output loop a moment to flush what the terminal printed last
+		select {
+		case <-outputDone:
+		case <-time.After(time.Second):
+		}
+		sess.finish("Terminal session ended")
+	}()
+
+	return sess, nil
+}
+
+// pumpOutput copies terminal output to the attached client, or into the
+// backlog while there is none. The terminal is read ahead of slow clients, who
+// are spared screen updates that were superseded meanwhile.
+func (sess *session) pumpOutput() {
+	queue := newOutputQueue()
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		var frames frameCoalescer
+		for {
+			data, behind := queue.take()
+			if data == nil {
+				return
+			}
+			if out := frames.coalesce(data, behind); len(out) < len(data) {
+				sess.logger.Debug().Str("session", sess.id).Int("bytes", len(data)-len(out)).Msg("Dropped superseded screen updates")
+				data = out
+			}
+			sess.output(data)
+		}
+	}()
+	defer func() {
+		queue.close()
+		<-delivered
+	}()
+
+	buf := make([]byte, 1024)
+	// Reads end anywhere, keep characters whole for clients decoding each message
+	var runes runeBuffer
+	for {
+		n, err := sess.proc.Read(buf)
+		if err != nil {
+			if err != io.EOF && !sess.closing.Load() && !isPTYClosedErr(err) {
+				sess.logger.Error().Err(fmt.Errorf("%w: %w", ErrPTY, err)).Msg("Error reading from PTY")
+			}
+			if rest := runes.flush(); len(rest) > 0 {
+				queue.put(rest)
+			}
+			return
+		}
+		sess.bytesOut.Add(int64(n))
+		sess.lastOutput.Store(time.Now().UnixNano())
+
+		if data := runes.align(buf[:n]); len(data) > 0 {
+			if sess.onOutput != nil {
+				sess.onOutput(sess, data)
+			}
+			queue.put(data)
+		}
+	}
+}
+
+// output sends data to the attached client and watchers, or into the backlog
+// while no client is attached
+func (sess *session) output(data []byte) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.broadcast(data)
+	switch {
+	case sess.conn != nil && sess.lowBandwidth:
+		sess.pending = append(sess.pending, data...)
+		if sess.flushTimer == nil {
+			sess.flushTimer = time.AfterFunc(lowBandwidthWindow, sess.flushPending)
+		}
+	case sess.conn != nil:
+		sess.send(data)
+	default:
+		sess.keep(data)
+	}
+}
+
+// send writes output to the attached client, dropping it if that fails.
+// sess.mu must be held.
+func (sess *session) send(data []byte) {
+	if err := sess.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		if !sess.closing.Load() && !isClosedErr(err) {
+			sess.logger.Error().Str("clientIP", sess.clientIP).Err(err).Msg("Error writing to WebSocket client")
+		}
+		sess.conn = nil
+	} else {
+		sess.framesOut.Add(1)
+	}
+}
+
+// keep adds output to the backlog for the next client to attach, sess.mu
+// must be held
+func (sess *session) keep(data []byte) {
+	sess.backlog = append(sess.backlog, data...)
+	if over := len(sess.backlog) - maxBacklog; over > 0 {
+		sess.backlog = trimToRune(sess.backlog[over:])
+	}
+}
+
+// flushPending sends the output gathered for a low bandwidth client once
+// lowBandwidthWindow has passed
+func (sess *session) flushPending() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.flushTimer = nil
+	sess.sendPending(false)
+}
+
+// sendPending thins and sends the output gathered for a low bandwidth client,
+// holding back an incomplete control sequence at its end unless all of it
+// must go now. Without a client, it goes into the backlog. sess.mu must be
+// held.
+func (sess *session) sendPending(all bool) {
+	if sess.flushTimer != nil {
+		sess.flushTimer.Stop()
+		sess.flushTimer = nil
+	}
+	if len(sess.pending) == 0 {
+		return
+	}
+	if sess.conn == nil {
+		sess.keep(sess.pending)
+		sess.pending = nil
+		return
+	}
+
+	cols := 0
+	if size, ok := sess.size.Load().(termSize); ok {
+		cols = size.cols
+	}
+	out, rest := thinOutput(sess.pending, cols)
+	if all {
+		out, rest = append(out, rest...), nil
+	}
+	sess.pending = append([]byte(nil), rest...)
+	if len(out) > 0 {
+		sess.send(out)
+	}
+}
+
+// attach makes conn the session's client, replaying output buffered
+// meanwhile. agreed are the protocol features the client and server use.
+func (sess *session) attach(conn *safeConn, agreed []string) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	select {
+	case <-sess.done:
+		return ErrSessionClosed
+	default:
+	}
+
+	// Whatever was gathered for the previous client goes out first
+	sess.sendPending(true)
+	if len(sess.backlog) > 0 {
+		if err := conn.WriteMessage(websocket.BinaryMessage, sess.backlog); err != nil {
+			return err
+		}
+		sess.framesOut.Add(1)
+		sess.backlog = nil
+	}
+	sess.conn = conn
+	sess.reportExit = slices.Contains(agreed, FeatureExitStatus)
+	sess.notices = slices.Contains(agreed, FeatureNotices)
+	sess.lowBandwidth = slices.Contains(agreed, FeatureLowBandwidth)
+	return nil
+}
+
+// detach drops the attached client, if it still is conn
+func (sess *session) detach(conn *safeConn) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.conn == conn {
+		sess.conn = nil
+		// Output it was still to get is kept for the next client
+		sess.sendPending(true)
+	}
+}
+
+// attached reports whether a client is attached
+func (sess *session) attached() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.conn != nil
+}
+
+// notify writes a highlighted notice line to the attached client, if any
+func (sess *session) notify(text string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.sendPending(true)
+	if sess.conn != nil {
+		sendNotice(sess.conn, sess.notices, text)
+	}
+}
+
+// sendNotice sends a notice to a client, in a notice message if it agreed
+// to FeatureNotices and as terminal output otherwise
+func sendNotice(conn *safeConn, notices bool, text string) error {
+	if notices {
+		return conn.WriteMessage(websocket.TextMessage, formatNotice(text))
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, formatNoticeLine(text))
+}
+
+// closeClient sends a close frame with reason to the attached client and drops it
+func (sess *session) closeClient(code int, reason string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.sendPending(true)
+	if sess.conn != nil {
+		// Ignore errors during close, as the connection might already be gone
+		sess.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+		sess.conn.Close()
+		sess.conn = nil
+	}
+}
+
+// resize sizes the terminal as a client's hello asks, if it gave a size
+func (sess *session) resize(hello Hello) {
+	if hello.Cols <= 0 || hello.Rows <= 0 {
+		return
+	}
+	size := termSize{cols: hello.Cols, rows: hello.Rows}
+	if err := sess.proc.Resize(size.cols, size.rows); err != nil {
+		sess.logger.Error().Err(err).Msg("Error resizing pty")
+		return
+	}
+	sess.size.Store(size)
+}
+
+// signal sends sig to the session's process, or its process group
+func (sess *session) signal(sig syscall.Signal, group bool) {
+	sess.proc.Signal(sig, group)
+}
+
+// terminate stops the process with sig, killing it if it has not exited after grace
+func (sess *session) terminate(sig syscall.Signal, grace time.Duration, group bool) {
+	sess.closing.Store(true)
+	// Signal while the terminal is still open, so the shell can pass a hangup on to its jobs
+	defer sess.proc.Close()
+	sess.signal(sig, group)
+	// Wait for process to exit or force kill after the grace period
+	select {
+	case <-sess.exited:
+		// Process exited cleanly
+	case <-time.After(grace):
+		// Force kill if it doesn't respond
+		sess.logger.Debug().Str("session", sess.id).Msg("Shell did not exit in time, killing it")
+		sess.signal(syscall.SIGKILL, group)
+	}
+}
+
+// finish ends the session, telling the attached client why
+func (sess *session) finish(reason string) {
+	sess.finishOnce.Do(func() {
+		sess.closing.Store(true)
+		sess.mu.Lock()
+		sess.sendPending(true)
+		if sess.conn != nil && sess.reportExit && sess.exitCode >= 0 {
+			sess.conn.WriteMessage(websocket.TextMessage, formatExitStatus(sess.exitCode))
+		}
+		sess.mu.Unlock()
+		sess.closeClient(websocket.CloseNormalClosure, reason)
+		sess.proc.Close()
+
+		sess.mu.Lock()
+		close(sess.done)
+		sess.closeWatchers()
+		for _, timer := range sess.shares {
+			timer.Stop()
+		}
+		sess.shares = nil
+		sess.mu.Unlock()
+
+		sess.logger.Info().Str("clientIP", sess.clientIP).Str("session", sess.id).
+			Str("duration", formatDuration(time.Since(sess.startTime))).Msg("Session ended")
+
+		if sess.onFinish != nil {
+			sess.onFinish(sess)
+		}
+	})
+}
+
+// exitStatus returns the exit status reported by a process's Wait, -1 if the
+// process was killed or the status is unknown
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// formatDuration renders a duration as hours, minutes and seconds for humans
+func formatDuration(duration time.Duration) string {
+	hours := int(duration.Hours())
+	minutes := int(duration.Minutes()) % 60
+	seconds := int(duration.Seconds()) % 60
+
+	if hours > 0 {
+		return fmt.Sprintf("%d hours, %d minutes, %d seconds", hours, minutes, seconds)
+	} else if minutes > 0 {
+		return fmt.Sprintf("%d minutes, %d seconds", minutes, seconds)
+	}
+	return fmt.Sprintf("%d seconds", seconds)
+}
+
+// randomHex returns n random bytes encoded as a hex string
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,162 @@
+package linkterm
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultShareTTL is how long share links stay valid unless asked otherwise
+	defaultShareTTL = 30 * time.Minute
+	// maxShareTTL bounds how long a share link may stay valid
+	maxShareTTL = 7 * 24 * time.Hour
+)
+
+// ShareLink grants read-only access to a running session until it expires or
+// is revoked
+type ShareLink struct {
+	URL     string    `json:"url"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// share creates the token of a share link valid for ttl
+func (sess *session) share(ttl time.Duration) (string, error) {
+	token, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	select {
+	case <-sess.done:
+		return "", ErrSessionClosed
+	default:
+	}
+	if sess.shares == nil {
+		sess.shares = make(map[string]*time.Timer)
+	}
+	sess.shares[token] = time.AfterFunc(ttl, func() {
+		sess.revokeShare(token, "Share link expired")
+	})
+	return token, nil
+}
+
+// shared reports whether token is one of the session's share links
+func (sess *session) shared(token string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, ok := sess.shares[token]
+	return ok
+}
+
+// revokeShare revokes the share link token, or all of them if empty,
+// disconnecting their viewers with reason, and returns how many it revoked
+func (sess *session) revokeShare(token, reason string) int {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	revoked := 0
+	for share, timer := range sess.shares {
+		if token != "" && share != token {
+			continue
+		}
+		timer.Stop()
+		delete(sess.shares, share)
+		revoked++
+		for w := range sess.watchers {
+			if w.share == share {
+				w.reason = reason
+				delete(sess.watchers, w)
+				close(w.out)
+			}
+		}
+	}
+	return revoked
+}
+
+// shareSession returns the session a share link request is for, answering
+// the request itself if the caller may not share it. Only the identity that
+// started a session can share it, so anonymous callers never can.
+func (s *Server) shareSession(w http.ResponseWriter, r *http.Request) (*session, string, bool) {
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", getClientIP(r)).Err(err).Msg("Rejected share link request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+	if !s.authorized(w, r, identity, r.URL.Path) {
+		return nil, "", false
+	}
+	if identity == "" {
+		// Without a login every session would be everyone's to share
+		s.logger.Warn().Str("clientIP", getClientIP(r)).Msg("Rejected anonymous share link request")
+		http.Error(w, "Share links need a login", http.StatusForbidden)
+		return nil, "", false
+	}
+
+	s.sessionsMu.Lock()
+	sess := s.sessions[r.PathValue("id")]
+	owned := sess != nil && sess.identity == identity
+	s.sessionsMu.Unlock()
+	if !owned {
+		// Sessions of others look like unknown ones
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	return sess, identity, true
+}
+
+// handleShareCreate creates a share link for a session, valid for the
+// duration in the ttl query parameter
+func (s *Server) handleShareCreate(w http.ResponseWriter, r *http.Request) {
+	sess, identity, ok := s.shareSession(w, r)
+	if !ok {
+		return
+	}
+	ttl := defaultShareTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxShareTTL {
+			http.Error(w, "Invalid ttl, want a duration up to "+maxShareTTL.String(), http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	token, err := sess.share(ttl)
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	link := ShareLink{URL: s.watchURL(r, sess.id, token), Token: token, Expires: time.Now().Add(ttl).UTC()}
+
+	clientIP := getClientIP(r)
+	s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Str("identity", identity).Time("expires", link.Expires).Msg("Created share link")
+	s.record(AuditEvent{Event: "share_created", Session: sess.id, ClientIP: clientIP, UserAgent: r.UserAgent(), Path: r.URL.Path, Identity: identity, Detail: "expires " + link.Expires.Format(time.RFC3339)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// handleShareRevoke revokes a session's share link, or all of them if the
+// request names none, disconnecting their viewers
+func (s *Server) handleShareRevoke(w http.ResponseWriter, r *http.Request) {
+	sess, identity, ok := s.shareSession(w, r)
+	if !ok {
+		return
+	}
+	token := r.PathValue("token")
+	revoked := sess.revokeShare(token, "Share link revoked")
+	if token != "" && revoked == 0 {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+
+	clientIP := getClientIP(r)
+	s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Str("identity", identity).Int("links", revoked).Msg("Revoked share links")
+	s.record(AuditEvent{Event: "share_revoked", Session: sess.id, ClientIP: clientIP, UserAgent: r.UserAgent(), Path: r.URL.Path, Identity: identity})
+	w.WriteHeader(http.StatusNoContent)
+}
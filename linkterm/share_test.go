@@ -0,0 +1,23 @@
+package linkterm_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/linksocks/linkterm/linkterm"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+func TestShareLinkRefusedWithoutLogin(t *testing.T) {
+	server := linkterm.NewServer(0, "", "sh")
+	server.Watch = true
+	srv := linktermtest.NewServer(server, linktermtest.NewBackend(linktermtest.Echo))
+	defer srv.Close()
+	_, id := openSession(t, srv, nil)
+
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		if status := request(t, srv, method, "/session/"+id+"/shares", nil); status != http.StatusForbidden {
+			t.Errorf("anonymous %s shares: got status %d, want %d", method, status, http.StatusForbidden)
+		}
+	}
+}
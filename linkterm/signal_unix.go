@@ -0,0 +1,53 @@
+//go:build !windows
+
+package linkterm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// signalNames maps the names accepted on the command line to signals
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal name such as TERM or SIGHUP
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// signalProcess sends sig to process, or to its whole process group if group is
+// set. Shells started on a PTY lead their own group and session, so their PID is
+// the group ID. Where supported, the rest of the session is signaled as well,
+// as job-control shells run background jobs in separate groups.
+func signalProcess(process *os.Process, sig syscall.Signal, group bool) error {
+	if !group {
+		return process.Signal(sig)
+	}
+
+	err := syscall.Kill(-process.Pid, sig)
+	for _, pid := range sessionMembers(process.Pid) {
+		syscall.Kill(pid, sig)
+	}
+	return err
+}
+
+// setProcessGroup makes cmd lead a process group of its own, so it can be
+// signaled with its children
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
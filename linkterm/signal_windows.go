@@ -0,0 +1,37 @@
+//go:build windows
+
+package linkterm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// signalNames maps the names accepted on the command line to signals
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal name such as TERM or SIGHUP
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// signalProcess sends sig to process. Windows has no process groups to signal,
+// so group is ignored.
+func signalProcess(process *os.Process, sig syscall.Signal, group bool) error {
+	return process.Signal(sig)
+}
+
+// setProcessGroup does nothing, Windows has no process groups to signal
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,66 @@
+package linkterm_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/linksocks/linkterm/linkterm"
+)
+
+func TestSignedURLAuth(t *testing.T) {
+	key := []byte("signing key")
+	auth := linkterm.SignedURLAuth{Key: key}
+	sign := func(ttl time.Duration, label string) string {
+		t.Helper()
+		signed, err := linkterm.SignURL("ws://linkterm.test/terminal", key, ttl, label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	tamper := func(rawURL, name, value string) string {
+		u, _ := url.Parse(rawURL)
+		query := u.Query()
+		query.Set(name, value)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	valid := sign(time.Hour, "bob")
+
+	u, _ := url.Parse(valid)
+	tests := []struct {
+		name     string
+		url      string
+		identity string // empty if the URL must be refused
+	}{
+		{"valid", valid, "url:bob"},
+		{"without a label", sign(time.Hour, ""), "url"},
+		{"expired", sign(-time.Minute, "bob"), ""},
+		{"label changed", tamper(valid, "id", "alice"), ""},
+		{"label removed", tamper(valid, "id", ""), ""},
+		{"expiry extended", tamper(valid, "exp", "99999999999"), ""},
+		{"other path", "ws://linkterm.test/admin?" + u.RawQuery, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := auth.Authenticate(httptest.NewRequest("GET", tt.url, nil))
+			if tt.identity == "" && err == nil {
+				t.Errorf("accepted as %q", identity)
+			} else if tt.identity != "" && (err != nil || identity != tt.identity) {
+				t.Errorf("got %q, %v, want %q", identity, err, tt.identity)
+			}
+		})
+	}
+
+	if _, err := auth.Authenticate(httptest.NewRequest("GET", "ws://linkterm.test/terminal", nil)); !errors.Is(err, linkterm.ErrNoCredentials) {
+		t.Errorf("unsigned URL: got %v, want ErrNoCredentials", err)
+	}
+
+	other := linkterm.SignedURLAuth{Key: []byte("another key")}
+	if _, err := other.Authenticate(httptest.NewRequest("GET", valid, nil)); err == nil {
+		t.Error("URL signed with another key accepted")
+	}
+}
@@ -0,0 +1,98 @@
+package linkterm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 as in RFC 1928, without authentication and for CONNECT only
+const (
+	socksVersion = 5
+	socksConnect = 1
+
+	socksSucceeded           byte = 0
+	socksNotAllowed          byte = 2
+	socksRefused             byte = 5
+	socksCommandNotSupported byte = 7
+	socksAddressNotSupported byte = 8
+)
+
+// socksTimeout bounds how long a SOCKS client may take to say where to
+const socksTimeout = 30 * time.Second
+
+// socksHandshake reads a SOCKS5 client's greeting and CONNECT request and
+// returns the host:port asked for. The caller replies with socksReply once
+// it knows whether the target can be reached.
+func socksHandshake(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(socksTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	if buf[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", buf[0])
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if !bytes.Contains(methods, []byte{0}) {
+		conn.Write([]byte{socksVersion, 0xff})
+		return "", errors.New("SOCKS client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, 0}); err != nil {
+		return "", err
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return "", err
+	}
+	if buf[1] != socksConnect {
+		socksReply(conn, socksCommandNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", buf[1])
+	}
+
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if buf[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", err
+		}
+		name := buf[:buf[0]]
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socksReply(conn, socksAddressNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", buf[3])
+	}
+
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	port := int(buf[0])<<8 | int(buf[1])
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// socksReply answers a CONNECT request, without telling the bound address
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
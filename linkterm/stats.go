@@ -0,0 +1,230 @@
+package linkterm
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxTrackedClients bounds the client IPs counted for Stats, further
+	// addresses are not counted once it is reached
+	maxTrackedClients = 4096
+	// topClients is how many of the busiest client IPs Stats reports
+	topClients = 10
+)
+
+// Stats summarizes a server's activity since it started
+type Stats struct {
+	Started          time.Time        `json:"started"`
+	UptimeSeconds    int64            `json:"uptimeSeconds"`
+	TotalSessions    int64            `json:"totalSessions"`
+	ActiveSessions   int              `json:"activeSessions"`
+	DetachedSessions int              `json:"detachedSessions"`
+	BytesIn          int64            `json:"bytesIn"`
+	BytesOut         int64            `json:"bytesOut"`
+	TopClients       []ClientStats    `json:"topClients"`
+	Errors           map[string]int64 `json:"errors"`
+}
+
+// ClientStats counts the connections made from one client IP
+type ClientStats struct {
+	IP          string `json:"ip"`
+	Connections int64  `json:"connections"`
+}
+
+// serverStats accumulates the counters behind Stats
+type serverStats struct {
+	started       time.Time
+	totalSessions atomic.Int64
+	// bytesIn and bytesOut hold the traffic of sessions that have ended,
+	// live sessions keep their own counts
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	mu      sync.Mutex
+	clients map[string]int64
+	errors  map[string]int64
+}
+
+// countClient counts a connection from clientIP
+func (st *serverStats) countClient(clientIP string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.clients == nil {
+		st.clients = make(map[string]int64)
+	}
+	if _, ok := st.clients[clientIP]; ok || len(st.clients) < maxTrackedClients {
+		st.clients[clientIP]++
+	}
+}
+
+// countError counts an error of the given kind
+func (st *serverStats) countError(kind string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.errors == nil {
+		st.errors = make(map[string]int64)
+	}
+	st.errors[kind]++
+}
+
+// Stats returns a snapshot of the server's activity since it started
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Started:       s.stats.started,
+		UptimeSeconds: int64(time.Since(s.stats.started).Seconds()),
+		TotalSessions: s.stats.totalSessions.Load(),
+		BytesIn:       s.stats.bytesIn.Load(),
+		BytesOut:      s.stats.bytesOut.Load(),
+		Errors:        map[string]int64{},
+	}
+
+	s.sessionsMu.Lock()
+	for _, sess := range s.sessions {
+		if sess.detached {
+			stats.DetachedSessions++
+		} else {
+			stats.ActiveSessions++
+		}
+		stats.BytesIn += sess.bytesIn.Load()
+		stats.BytesOut += sess.bytesOut.Load()
+	}
+	s.sessionsMu.Unlock()
+
+	s.stats.mu.Lock()
+	for ip, count := range s.stats.clients {
+		stats.TopClients = append(stats.TopClients, ClientStats{IP: ip, Connections: count})
+	}
+	for kind, count := range s.stats.errors {
+		stats.Errors[kind] = count
+	}
+	s.stats.mu.Unlock()
+
+	sort.Slice(stats.TopClients, func(i, j int) bool {
+		a, b := stats.TopClients[i], stats.TopClients[j]
+		return a.Connections > b.Connections || a.Connections == b.Connections && a.IP < b.IP
+	})
+	if len(stats.TopClients) > topClients {
+		stats.TopClients = stats.TopClients[:topClients]
+	}
+	return stats
+}
+
+// SessionInfo describes a live session in the server's session listing
+type SessionInfo struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	ClientIP string    `json:"clientIP"`
+	Identity string    `json:"identity,omitempty"`
+	Started  time.Time `json:"started"`
+	Detached bool      `json:"detached"`
+	// LastActivity is when the client last typed or the shell last printed
+	LastActivity time.Time `json:"lastActivity"`
+	// Cols and Rows are the terminal size, 0 until the client has sent one
+	Cols      int   `json:"cols"`
+	Rows      int   `json:"rows"`
+	BytesIn   int64 `json:"bytesIn"`
+	BytesOut  int64 `json:"bytesOut"`
+	FramesIn  int64 `json:"framesIn"`
+	FramesOut int64 `json:"framesOut"`
+	// ClientVersion and ClientPlatform are from the client's hello, empty
+	// for clients without FeatureHello
+	ClientVersion  string `json:"clientVersion,omitempty"`
+	ClientPlatform string `json:"clientPlatform,omitempty"`
+	// Tags are the labels the client gave the session
+	Tags map[string]string `json:"tags,omitempty"`
+	// Usage is missing if the backend cannot measure it
+	Usage *ProcessUsage `json:"usage,omitempty"`
+}
+
+// Sessions returns the live sessions, oldest first, sampling the resources
+// their processes use
+func (s *Server) Sessions() []SessionInfo {
+	s.sessionsMu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	infos := make([]SessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+		infos = append(infos, SessionInfo{
+			ID:       sess.id,
+			Path:     sess.endpoint.Path,
+			ClientIP: sess.clientIP,
+			Identity: sess.identity,
+			Started:  sess.startTime,
+			Detached: sess.detached,
+
+			ClientVersion:  sess.hello.Version,
+			ClientPlatform: sess.hello.Platform,
+			Tags:           sess.hello.Tags,
+		})
+	}
+	s.sessionsMu.Unlock()
+
+	// Sampling reads /proc, which is done without holding up new sessions
+	for i, sess := range sessions {
+		infos[i].BytesIn = sess.bytesIn.Load()
+		infos[i].BytesOut = sess.bytesOut.Load()
+		infos[i].FramesIn = sess.framesIn.Load()
+		infos[i].FramesOut = sess.framesOut.Load()
+		infos[i].LastActivity = time.Unix(0, max(sess.lastInput.Load(), sess.lastOutput.Load()))
+		if size, ok := sess.size.Load().(termSize); ok {
+			infos[i].Cols, infos[i].Rows = size.cols, size.rows
+		}
+		if reporter, ok := sess.proc.(UsageReporter); ok {
+			if usage, ok := reporter.Usage(); ok {
+				infos[i].Usage = &usage
+			}
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// handleSessions serves Sessions as JSON to admins, as the IDs let them
+// reattach. Each tag query parameter, a name or name=value, limits them to
+// the sessions tagged so.
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", getClientIP(r)).Err(err).Msg("Rejected session listing request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, r.URL.Path) || !s.admin(w, r, identity) {
+		return
+	}
+	sessions := s.Sessions()
+	if filters := r.URL.Query()["tag"]; len(filters) > 0 {
+		matched := sessions[:0]
+		for _, sess := range sessions {
+			if matchTags(sess.Tags, filters) {
+				matched = append(matched, sess)
+			}
+		}
+		sessions = matched
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// handleStats serves Stats as JSON to admins
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", getClientIP(r)).Err(err).Msg("Rejected stats request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, r.URL.Path) || !s.admin(w, r, identity) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Stats())
+}
@@ -0,0 +1,86 @@
+package linkterm_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/linksocks/linkterm/linkterm"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+func TestAdminEndpointsForAdminsOnly(t *testing.T) {
+	allowAll := func(context.Context, linkterm.AuthorizationRequest) error { return nil }
+	tests := []struct {
+		name       string
+		login      bool
+		admins     []string
+		authorizer bool
+		user       string
+		want       int
+	}{
+		{"anonymous without logins", false, nil, false, "", http.StatusForbidden},
+		{"anonymous with an authorizer", false, nil, true, "", http.StatusForbidden},
+		{"no admins", true, nil, false, "alice", http.StatusForbidden},
+		{"listed admin", true, []string{"alice"}, false, "alice", http.StatusOK},
+		{"unlisted user", true, []string{"alice"}, false, "bob", http.StatusForbidden},
+		{"unlisted user despite the authorizer", true, []string{"alice"}, true, "bob", http.StatusForbidden},
+		{"authorized without a list", true, nil, true, "bob", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := linkterm.NewServer(0, "", "sh")
+			server.ServeStats = true
+			server.ServeEvents = true
+			server.Admins = tt.admins
+			if tt.login {
+				server.AddAuthenticator(userAuth)
+			}
+			if tt.authorizer {
+				server.SetAuthorizer(allowAll)
+			}
+			srv := linktermtest.NewServer(server, nil)
+			defer srv.Close()
+
+			var header http.Header
+			if tt.user != "" {
+				header = http.Header{"X-User": {tt.user}}
+			}
+			for _, path := range []string{"/stats", "/sessions", "/events"} {
+				if status := request(t, srv, http.MethodGet, path, header); status != tt.want {
+					t.Errorf("GET %s: got status %d, want %d", path, status, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefixMountsEveryEndpoint(t *testing.T) {
+	server := linkterm.NewServer(0, "", "sh")
+	server.Prefix = "/secret"
+	server.Path = "/secret/terminal"
+	server.ServeStats = true
+	server.ServeEvents = true
+	server.History = true
+	server.Echo = true
+	server.Forward = true
+	server.Exec = true
+	server.Admins = []string{"alice"}
+	server.AddAuthenticator(userAuth)
+	srv := linktermtest.NewServer(server, linktermtest.NewBackend(linktermtest.Echo))
+	defer srv.Close()
+
+	if srv.URL != "ws://linkterm.test/secret/terminal" {
+		t.Errorf("terminal at %s, want it under the prefix", srv.URL)
+	}
+	header := http.Header{"X-User": {"alice"}}
+	openSession(t, srv, header)
+	for _, path := range []string{"/stats", "/sessions", "/events", "/session/none/history", linkterm.EchoPath, linkterm.ForwardPath, linkterm.ExecPath, linkterm.DefaultPath} {
+		if status := request(t, srv, http.MethodGet, path, header); status != http.StatusNotFound {
+			t.Errorf("GET %s: got status %d, want it only under the prefix", path, status)
+		}
+		if status := request(t, srv, http.MethodGet, "/secret"+path, header); status == http.StatusNotFound && path != "/session/none/history" {
+			t.Errorf("GET /secret%s: not found", path)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package linkterm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// statusLine draws a bar on the bottom row of the local terminal, keeping
+// the session out of it with a scroll region. All output to the terminal
+// goes through it, so the bar is never drawn in the middle of an escape
+// sequence.
+type statusLine struct {
+	mu  sync.Mutex
+	out io.Writer
+	// text returns the bar's content
+	text func() string
+
+	cols, rows int
+	// active is set while the scroll region is in place
+	active bool
+	closed bool
+	// partial is set while the last output ended inside an escape sequence
+	partial bool
+}
+
+func newStatusLine(out io.Writer, text func() string) *statusLine {
+	return &statusLine{out: out, text: text}
+}
+
+// Write passes session output through
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = incompleteEscape(p)
+	return s.out.Write(p)
+}
+
+// resize fits the bar to a terminal of cols by rows, returning the rows left
+// for the session. Terminals too small for a bar are left alone.
+func (s *statusLine) resize(cols, rows int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || rows < 3 {
+		return rows
+	}
+
+	if !s.active {
+		// Scroll the screen up a line so the bar does not cover the cursor's line
+		fmt.Fprint(s.out, "\n\033[A")
+		s.active = true
+	}
+	s.cols, s.rows = cols, rows
+	// Setting the scroll region homes the cursor, so it is saved around it
+	fmt.Fprintf(s.out, "\0337\033[1;%dr\0338", rows-1)
+	s.draw()
+	return rows - 1
+}
+
+// refresh redraws the bar with its current content
+func (s *statusLine) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active && !s.closed && !s.partial {
+		s.draw()
+	}
+}
+
+// draw writes the bar to the bottom row, s.mu must be held
+func (s *statusLine) draw() {
+	text := s.text()
+	if utf8.RuneCountInString(text) > s.cols {
+		text = string([]rune(text)[:s.cols])
+	}
+	fmt.Fprintf(s.out, "\0337\033[%d;1H\033[2K\033[7m%-*s\033[0m\0338", s.rows, s.cols, text)
+}
+
+// close removes the bar and gives the session the whole terminal back
+func (s *statusLine) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active && !s.closed {
+		fmt.Fprintf(s.out, "\0337\033[r\033[%d;1H\033[2K\0338", s.rows)
+	}
+	s.closed = true
+}
+
+// incompleteEscape reports whether p ends inside an escape sequence
+func incompleteEscape(p []byte) bool {
+	i := bytes.LastIndexByte(p, 0x1b)
+	if i < 0 {
+		return false
+	}
+	rest := p[i+1:]
+	if len(rest) == 0 {
+		return true
+	}
+	switch rest[0] {
+	case '[':
+		// CSI ends with a byte from @ to ~
+		return bytes.IndexFunc(rest[1:], func(r rune) bool { return r >= '@' && r <= '~' }) < 0
+	case ']', 'P', '_':
+		// OSC and other strings end with BEL, or ST which would be the last ESC
+		return bytes.IndexByte(rest, 0x07) < 0
+	case '(', ')', '#', '%':
+		return len(rest) < 2
+	}
+	return false
+}
+
+// runStatusLine refreshes the bar every second until done is closed
+func (c *Client) runStatusLine(status *statusLine, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			status.refresh()
+		}
+	}
+}
+
+// windowTitle returns who and where the client connects to, as user@host if
+// the URL names a user
+func (c *Client) windowTitle() string {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return c.URL
+	}
+	if name := u.User.Username(); name != "" {
+		return name + "@" + u.Hostname()
+	}
+	return u.Host
+}
+
+// statusText returns the content of the status line of a session that
+// started at start: the server, how long the session has lasted and the
+// round trip time once measured
+func (c *Client) statusText(start time.Time) func() string {
+	server := c.URL
+	if u, err := url.Parse(c.URL); err == nil {
+		server = u.Host
+	}
+	return func() string {
+		elapsed := time.Since(start)
+		text := fmt.Sprintf(" linkterm  %s  %02d:%02d:%02d", server,
+			int(elapsed.Hours()), int(elapsed.Minutes())%60, int(elapsed.Seconds())%60)
+		if quality := c.Quality(); quality.Samples > 0 {
+			text += fmt.Sprintf("  rtt %s", quality.RTT.Round(100*time.Microsecond))
+		}
+		return text
+	}
+}
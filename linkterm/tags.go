@@ -0,0 +1,76 @@
+package linkterm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxTags is how many tags the server keeps of a session
+	maxTags = 16
+	// maxTagLength bounds the length of tag names and values
+	maxTagLength = 128
+)
+
+// ParseTags parses name=value labels, such as "purpose=incident-1234", into
+// the tags a client sends with its hello. A label without = has an empty value.
+func ParseTags(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	if len(specs) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are kept", maxTags)
+	}
+	tags := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		name, value, _ := strings.Cut(spec, "=")
+		if !validTag(name, value) {
+			return nil, fmt.Errorf("invalid tag %q, want name=value up to %d characters each", spec, maxTagLength)
+		}
+		tags[name] = value
+	}
+	return tags, nil
+}
+
+// validTag reports whether a tag is one the server keeps
+func validTag(name, value string) bool {
+	return name != "" && len(name) <= maxTagLength && len(value) <= maxTagLength &&
+		!strings.ContainsAny(name, "=\x00") && !strings.Contains(value, "\x00")
+}
+
+// acceptedTags returns the valid tags of a client's hello, up to maxTags of
+// them by name
+func acceptedTags(tags map[string]string) map[string]string {
+	names := make([]string, 0, len(tags))
+	for name, value := range tags {
+		if validTag(name, value) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	if len(names) > maxTags {
+		names = names[:maxTags]
+	}
+	accepted := make(map[string]string, len(names))
+	for _, name := range names {
+		accepted[name] = tags[name]
+	}
+	return accepted
+}
+
+// matchTags reports whether tags satisfy every filter, each either a name the
+// tags must have or a name=value they must have with that value
+func matchTags(tags map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		name, value, hasValue := strings.Cut(filter, "=")
+		got, ok := tags[name]
+		if !ok || hasValue && got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,20 @@
 package linkterm
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,9 +25,158 @@ import (
 
 // Client represents a terminal client
 type Client struct {
-	URL    string
+	URL string
+
+	// SessionID reattaches to a session the server kept after a disconnect
+	SessionID string
+
+	// MaxMessageSize is the largest WebSocket message accepted from the
+	// server, defaults to DefaultMaxMessageSize
+	MaxMessageSize int64
+
+	// Family restricts the IP versions used to reach the server, by default
+	// IPv6 and IPv4 addresses are raced
+	Family AddressFamily
+
+	// ConnectTimeout bounds each connection attempt, including the
+	// WebSocket handshake, defaults to 5 seconds
+	ConnectTimeout time.Duration
+
+	// ConnectRetries is how many times a failed connection attempt is
+	// retried, with growing delays, before giving up. Refusals by the server
+	// such as authentication failures are not retried.
+	ConnectRetries int
+
+	// Predict echoes typed characters locally, underlined until the server
+	// confirms them, to hide latency on slow links
+	Predict bool
+
+	// KeepAlive sends a WebSocket ping this often, keeping NAT and proxy
+	// mappings alive while the session is idle, 0 disables it. The pongs
+	// measure the connection's Quality.
+	KeepAlive time.Duration
+
+	// QualityLog prints the estimated round trip time and jitter this often,
+	// 0 disables it. The server is pinged even without KeepAlive.
+	QualityLog time.Duration
+
+	// NoNotify ignores requests for desktop notifications, which programs in
+	// the session make with OSC 9 and OSC 777. They are only shown when the
+	// client uses the process's terminal, and always sent to Events.
+	NoNotify bool
+
+	// BellNotify raises a desktop notification when the session rings the
+	// bell, always or only while the terminal window is unfocused. Focus is
+	// known from the terminal's focus reports, which not all terminals send.
+	BellNotify BellNotify
+
+	// KeepTitle leaves the local terminal's window title alone. Otherwise it
+	// is set to the server's address while connected and restored after.
+	KeepTitle bool
+
+	// StatusLine reserves the bottom row of the terminal for a bar showing
+	// the server, the session's duration and the round trip time. The
+	// session is told the terminal is a row shorter.
+	StatusLine bool
+
+	// LowBandwidth asks the server to thin the output for a slow link, such
+	// as satellite or 2G: colors are left out and progress lines rewritten
+	// faster than they can be shown are skipped. Output is sent in batches,
+	// adding up to a tenth of a second to echoes.
+	LowBandwidth bool
+
+	// ReadBufferSize and WriteBufferSize size the I/O buffers of the
+	// connection, 0 keeps the dialer's setting
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// WriteBufferPool shares write buffers between the client's connections
+	WriteBufferPool websocket.BufferPool
+
+	// InitCommand is typed into the shell once connected, e.g. to start htop
+	// or change directory. It is not sent when reattaching to a session.
+	InitCommand string
+
+	// HideInitCommand keeps the shell's echo of InitCommand off the screen
+	HideInitCommand bool
+
+	// Header is sent with the WebSocket handshake, e.g. an Authorization
+	// header for servers that require login
+	Header http.Header
+
+	// KnownHostsFile pins servers' host keys. Servers seen for the first
+	// time are trusted and recorded unless StrictHostKeys is set, servers
+	// presenting another key are refused. Empty skips the check.
+	KnownHostsFile string
+	StrictHostKeys bool
+
+	// Jump lists linkterm servers to connect through in turn, like ssh's
+	// ProxyJump. Each must serve Forward. They are sent Header without its
+	// Authorization and Cookie, which are for the server at URL only, and
+	// log in with the credentials or access token in their own URLs.
+	Jump []string
+
+	// ReverseSOCKS has the server listen on this loopback address while
+	// connected, serving a SOCKS5 proxy into the client's network there.
+	// ReverseSOCKSAllow limits the targets to those matching one of its
+	// host:port patterns, without any every target is refused. The server
+	// must allow RemoteForward.
+	ReverseSOCKS      string
+	ReverseSOCKSAllow []string
+
+	// GPGAgent is the socket of the local gpg-agent to forward into the
+	// session, best its restricted extra socket. The server must allow
+	// GPGAgentForward.
+	GPGAgent string
+
+	// SendEnv are patterns of the local environment variables to send for
+	// the remote shell, such as "GIT_AUTHOR_*". The server only sets those
+	// its AcceptEnv allows.
+	SendEnv []string
+
+	// ProxyCommand is run to reach the server, the connection going over its
+	// stdin and stdout, as with ssh's ProxyCommand. %h and %p are replaced by
+	// the server's host and port. It takes the place of the dialer's proxy.
+	ProxyCommand string
+
+	// Stdin, Stdout and Stderr replace the process's terminal, e.g. to drive a
+	// session from a GUI or a test. The session ends when Stdin returns an
+	// error. With Stdin set, the terminal is not put into raw mode and
+	// interrupt signals are left alone. Stderr receives the client's own
+	// messages, which otherwise go to Stdout with the session's output.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Size reports the size of the terminal in place of the process's
+	// terminal. It is called again after each send on Resized, which is only
+	// watched when Size is set.
+	Size    func() (cols, rows int, err error)
+	Resized <-chan struct{}
+
+	// Term is the terminal type the server's shell is told in TERM, defaults
+	// to the local TERM. Servers without FeatureHello ignore it.
+	Term string
+
+	// Tags label the session for the server's session listing, such as
+	// purpose=incident-1234. Servers without FeatureHello ignore them.
+	Tags map[string]string
+
+	// Events receives what happens to the session, ending with a
+	// ClosedEvent once Connect is done. The client waits for each event to
+	// be received, so the channel must be read promptly or buffered.
+	Events chan<- Event
+
+	quality qualityEstimator
+
+	// userinfo holds the credentials given in the URL, sent with basic
+	// authentication unless Header authorizes already
+	userinfo *url.Userinfo
+
 	dialer *websocket.Dialer
-	logger zerolog.Logger
+	// proxied is set when the dialer goes through a proxy or tunnel we were given
+	proxied bool
+	logger  zerolog.Logger
 }
 
 // NewClient creates a new terminal client
@@ -36,6 +194,9 @@ func NewClient(url string) *Client {
 		url = "ws://" + url
 	}
 
+	// WebSocket URLs cannot carry credentials, they go in the handshake
+	url, userinfo := cutUserinfo(url)
+
 	// Parse URL to check path
 	parts := strings.SplitN(url, "/", 4)
 	if len(parts) == 3 { // scheme://domain
@@ -43,15 +204,62 @@ func NewClient(url string) *Client {
 	}
 
 	return &Client{
-		URL:    url,
-		dialer: websocket.DefaultDialer,
-		logger: zerolog.Nop(), // Default no-op logger
+		URL:            url,
+		userinfo:       userinfo,
+		MaxMessageSize: DefaultMaxMessageSize,
+		ConnectTimeout: 5 * time.Second,
+		dialer:         websocket.DefaultDialer,
+		logger:         zerolog.Nop(), // Default no-op logger
+	}
+}
+
+// cutUserinfo removes the user name and password from rawURL, returning them
+// separately. They run up to the last @ before any query, so a password
+// may hold a / or @ without escaping it.
+func cutUserinfo(rawURL string) (string, *url.Userinfo) {
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	authority := rest
+	if end := strings.IndexAny(rest, "?#"); end >= 0 {
+		authority = rest[:end]
+	}
+	at := strings.LastIndex(authority, "@")
+	if !ok || at < 0 {
+		return rawURL, nil
+	}
+
+	user, password, hasPassword := strings.Cut(rest[:at], ":")
+	userinfo := url.User(unescapeUserinfo(user))
+	if hasPassword {
+		userinfo = url.UserPassword(unescapeUserinfo(user), unescapeUserinfo(password))
+	}
+	u, err := url.Parse(scheme + "://" + userinfo.String() + "@" + rest[at+1:])
+	if err != nil {
+		return scheme + "://" + rest[at+1:], userinfo
 	}
+	userinfo = u.User
+	u.User = nil
+	return u.String(), userinfo
+}
+
+// unescapeUserinfo decodes the %XX escapes of a user name or password,
+// keeping it as written if it has a stray %
+func unescapeUserinfo(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// basicAuth returns the Authorization header value logging in as userinfo
+func basicAuth(userinfo *url.Userinfo) string {
+	password, _ := userinfo.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(userinfo.Username()+":"+password))
 }
 
 // SetCustomDialer sets a custom websocket dialer for the client
 func (c *Client) SetCustomDialer(dialer *websocket.Dialer) {
 	c.dialer = dialer
+	c.proxied = dialer != nil && dialer.Proxy != nil
 }
 
 // SetLogger sets the logger for the client
@@ -59,42 +267,104 @@ func (c *Client) SetLogger(logger zerolog.Logger) {
 	c.logger = logger
 }
 
+// stdout returns where session output goes
+func (c *Client) stdout() io.Writer {
+	if c.Stdout != nil {
+		return c.Stdout
+	}
+	return os.Stdout
+}
+
+// stderr returns where the client's own messages go
+func (c *Client) stderr() io.Writer {
+	if c.Stderr != nil {
+		return c.Stderr
+	}
+	return c.stdout()
+}
+
 // Connect connects to the terminal server and starts the terminal session
 func (c *Client) Connect() error {
 	c.logger.Info().Str("url", c.URL).Msg("Connecting to terminal server")
 
-	// Use custom dialer if set, or the default one
-	dialer := c.dialer
-	if dialer == nil {
-		dialer = websocket.DefaultDialer
+	wsConn, agreed, err := c.open(c.URL)
+	if err != nil {
+		return err
 	}
 
-	dialer.HandshakeTimeout = 5 * time.Second
+	// Input, resize and close messages are written from different goroutines
+	conn := newSafeConn(wsConn)
+	if c.MaxMessageSize > 0 {
+		conn.SetReadLimit(c.MaxMessageSize)
+	}
 
-	// Set User-Agent header: LinkTerm/{version} {SystemInfo}
-	header := make(map[string][]string)
-	header["User-Agent"] = []string{fmt.Sprintf("LinkTerm/%s %s", Version, Platform)}
+	// Servers agreeing to hellos start the shell once they have ours
+	var server *Hello
+	if agreed[FeatureHello] {
+		h, err := c.hello(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		server = &h
+		c.logger.Debug().Str("version", h.Version).Str("platform", h.Platform).Strs("features", h.Features).Msg("Server said hello")
+	}
 
-	conn, resp, err := dialer.Dial(c.URL, header)
-	if err != nil {
-		if resp != nil {
-			return fmt.Errorf("failed to connect to terminal server: HTTP %d - %s", resp.StatusCode, err)
+	// The reverse SOCKS proxy lives as long as the session
+	if c.ReverseSOCKS != "" {
+		listener, err := c.listenRemote(context.Background(), ListenPath, url.Values{"addr": {c.ReverseSOCKS}})
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("reverse SOCKS proxy: %w", err)
+		}
+		defer listener.close()
+		c.logger.Info().Str("addr", listener.addr).Msg("Offering the server a SOCKS proxy into the local network")
+		go c.serveRemote(listener, c.reverseSOCKS)
+	}
+	if c.GPGAgent != "" {
+		listener, err := c.listenRemote(context.Background(), GPGAgentPath, nil)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("gpg-agent forwarding: %w", err)
 		}
-		return fmt.Errorf("failed to connect to terminal server: %w", err)
+		defer listener.close()
+		c.logger.Info().Str("socket", listener.addr).Msg("Forwarding gpg-agent")
+		go c.forwardGPGAgent(listener)
 	}
 
 	// Record connection start time
 	startTime := time.Now()
 	c.logger.Info().Str("url", c.URL).Msg("Connected to terminal server")
+	c.emit(ConnectedEvent{URL: c.URL, Server: server})
 
 	// Track if disconnected message has been displayed
 	var disconnectOnce sync.Once
 	var hasDisconnected bool
 
+	// closed is reported once the session is over, the server's reason and
+	// exit status take precedence
+	var closedMu sync.Mutex
+	closed := ClosedEvent{ExitCode: -1}
+	// emitters are the goroutines sending events, which must be done before
+	// the last one
+	var emitters sync.WaitGroup
+
+	// sent and received count the bytes of input and output messages
+	var sent, received atomic.Int64
+
+	// rejected receives the reason if the server turns us away after the handshake
+	rejected := make(chan error, 1)
+
 	// Create a function to handle disconnection with duration
 	disconnect := func(reason string) {
 		disconnectOnce.Do(func() {
 			hasDisconnected = true
+			closedMu.Lock()
+			if closed.Reason == "" {
+				closed.Reason = reason
+			}
+			closedMu.Unlock()
+
 			duration := time.Since(startTime)
 			hours := int(duration.Hours())
 			minutes := int(duration.Minutes()) % 60
@@ -112,7 +382,7 @@ func (c *Client) Connect() error {
 
 			// Reset line before printing disconnect message
 			zerolog.SetGlobalLevel(zerolog.ErrorLevel)
-			fmt.Printf("\n\r\033[KDisconnected from terminal server after %s (%s)\n", durationStr, reason)
+			fmt.Fprintf(c.stderr(), "\n\r\033[KDisconnected from terminal server after %s (%s)\n", durationStr, reason)
 		})
 	}
 
@@ -126,104 +396,214 @@ func (c *Client) Connect() error {
 		if !hasDisconnected {
 			disconnect("client closed")
 		}
-	}()
 
-	// Handle graceful shutdown on interrupt
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-interruptChan
-		fmt.Println("\nReceived interrupt, disconnecting...")
-		// Try to close gracefully
-		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client disconnected")
-		conn.WriteMessage(websocket.CloseMessage, closeMsg)
-		conn.Close()
-		disconnect("interrupted by user")
-		os.Exit(0)
+		emitters.Wait()
+		closedMu.Lock()
+		event := closed
+		closedMu.Unlock()
+		event.BytesSent = sent.Load()
+		event.BytesReceived = received.Load()
+		c.emit(event)
 	}()
 
-	// Put the local terminal into raw mode
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		return fmt.Errorf("failed to put terminal into raw mode: %w", err)
+	// Set up channels for coordinating exit
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	finish := func() {
+		doneOnce.Do(func() { close(done) })
 	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
 
-	// Get terminal size and send it
-	width, height, err := term.GetSize(int(os.Stdin.Fd()))
-	if err != nil {
-		fmt.Printf("Warning: could not get terminal size: %v", err)
-	} else {
-		resizeMsg := fmt.Sprintf("resize:%d:%d", width, height)
-		if err := conn.WriteMessage(websocket.TextMessage, []byte(resizeMsg)); err != nil {
-			fmt.Printf("Warning: could not send terminal size: %v", err)
+	// The process's own terminal is only touched when no input was given
+	input := c.Stdin
+	if input == nil {
+		// Handle graceful shutdown on interrupt
+		interruptChan := make(chan os.Signal, 1)
+		signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interruptChan)
+
+		go func() {
+			select {
+			case <-interruptChan:
+			case <-done:
+				return
+			}
+			fmt.Fprintln(c.stderr(), "\nReceived interrupt, disconnecting...")
+			// Try to close gracefully
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client disconnected")
+			conn.WriteMessage(websocket.CloseMessage, closeMsg)
+			conn.Close()
+			disconnect("interrupted by user")
+			finish()
+		}()
+
+		// Put the local terminal into raw mode
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return fmt.Errorf("failed to put terminal into raw mode: %w", err)
 		}
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+
+		input = terminalInput()
 	}
 
-	// Handle terminal resize
-	sigwinchCh := setupResizeHandler()
+	// The status line sits between the session and the terminal
+	out := c.stdout()
+	var status *statusLine
+	if c.StatusLine {
+		status = newStatusLine(out, c.statusText(startTime))
+		out = status
+		defer status.close()
+		go c.runStatusLine(status, done)
+	}
 
+	// Name the window after the server, saving the title on the terminal's
+	// title stack to restore it after
+	if c.Stdout == nil && !c.KeepTitle {
+		fmt.Fprintf(out, "\033[22;0t\033]0;%s (linkterm)\007", c.windowTitle())
+		defer fmt.Fprint(out, "\033[23;0t")
+	}
+
+	// Notifications are only raised from the process's terminal
+	var notifier *desktopNotifier
+	if c.Stdout == nil && (!c.NoNotify || c.BellNotify != BellNotifyOff) {
+		notifier = &desktopNotifier{logger: c.logger}
+	}
+	var focus *focusTracker
+	if notifier != nil && c.Stdin == nil && c.BellNotify == BellNotifyUnfocused {
+		focus = newFocusTracker()
+		out.Write(focusReportingOn)
+		defer out.Write(focusReportingOff)
+	}
+
+	// Send the terminal size now and whenever it changes
+	ackResize := agreed[FeatureResizeAck]
+	reportsExit := agreed[FeatureExitStatus]
+	notices := agreed[FeatureNotices]
+	resizeAcks := make(chan resizeAck, 4)
+	sized := make(chan struct{})
+	emitters.Add(1)
 	go func() {
-		for range sigwinchCh {
-			width, height, err := term.GetSize(int(os.Stdin.Fd()))
-			if err != nil {
-				continue
-			}
+		defer emitters.Done()
+		c.syncSize(conn, ackResize, resizeAcks, status, sized, done)
+	}()
 
-			resizeMsg := fmt.Sprintf("resize:%d:%d", width, height)
-			if err := conn.WriteMessage(websocket.TextMessage, []byte(resizeMsg)); err != nil {
-				if !strings.Contains(err.Error(), "use of closed") {
-					fmt.Printf("Warning: could not send terminal size: %v", err)
-				}
-				return
-			}
+	// Pongs are handled by the read loop below
+	c.quality.reset()
+	conn.SetPongHandler(func(payload string) error {
+		if rtt, ok := pongRTT(payload, time.Now()); ok {
+			c.quality.sample(rtt)
 		}
-	}()
+		return nil
+	})
+	if interval := c.KeepAlive; interval > 0 || c.QualityLog > 0 || c.StatusLine {
+		if interval <= 0 {
+			interval = qualityPing
+		}
+		go c.keepAlive(conn, interval, done)
+	}
+	if c.QualityLog > 0 {
+		go c.logQuality(done)
+	}
 
-	// Set up channels for coordinating exit
-	done := make(chan struct{})
+	// A new shell gets the initial command, possibly without showing its echo
+	sendInit := c.InitCommand != "" && c.SessionID == ""
+	var hideEcho *echoFilter
+	if sendInit && c.HideInitCommand {
+		hideEcho = newEchoFilter(c.InitCommand)
+	}
+
+	// Output goes through the predictor when local echo is predicted
+	writeOutput := out.Write
+	var predict *predictor
+	if c.Predict {
+		predict = newPredictor(out)
+		writeOutput = predict.output
+	}
 
 	// Send terminal input to WebSocket
 	go func() {
 		buf := make([]byte, 1024)
 		for {
-			n, err := os.Stdin.Read(buf)
+			n, err := input.Read(buf)
 			if err != nil {
-				close(done)
+				finish()
 				return
 			}
 
-			err = conn.WriteMessage(websocket.TextMessage, buf[:n])
+			data := buf[:n]
+			if focus != nil {
+				if data = focus.input(data); len(data) == 0 {
+					continue
+				}
+			}
+
+			if predict != nil {
+				// Record before sending, the echo may come back before WriteMessage returns
+				predict.input(data)
+			}
+
+			err = conn.WriteMessage(websocket.TextMessage, data)
 			if err != nil {
 				// Only log if not a normal closure
-				if !strings.Contains(err.Error(), "use of closed") &&
+				if !isClosedErr(err) &&
 					!websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					fmt.Printf("Error writing to WebSocket: %v", err)
+					fmt.Fprintf(c.stderr(), "Error writing to WebSocket: %v", err)
 				}
-				close(done)
+				finish()
 				return
 			}
+			sent.Add(int64(len(data)))
 		}
 	}()
 
 	// Receive terminal output from WebSocket
+	emitters.Add(1)
 	go func() {
-		defer close(done)
+		defer emitters.Done()
+		defer finish()
+		// Older servers may split characters across messages, which would
+		// garble them around the predictor's escape sequences
+		var runes runeBuffer
+		var scanner oscScanner
+		oscNotifier := notifier
+		if c.NoNotify {
+			oscNotifier = nil
+		}
 		for {
 			messageType, message, err := conn.ReadMessage()
 			if err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) && closeErr.Text != "" {
+					closedMu.Lock()
+					closed.Reason = closeErr.Text
+					closedMu.Unlock()
+				}
+				if errors.As(err, &closeErr) && closeErr.Code == websocket.ClosePolicyViolation {
+					rejected <- fmt.Errorf("%w: %s", ErrAuthFailed, closeErr.Text)
+					disconnect("rejected by server")
+					return
+				}
+				if errors.As(err, &closeErr) && closeErr.Code == CloseShellFailed {
+					rejected <- fmt.Errorf("%w: server %s", ErrPTY, closeErr.Text)
+					disconnect("shell failed to start")
+					return
+				}
+
 				// Check if it's a normal closure or abnormal
 				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) ||
-					strings.Contains(err.Error(), "use of closed") {
+					isClosedErr(err) {
 					// Normal close, show normal disconnect message
 					disconnect("client closed")
 					return
 				}
 
 				// Reset terminal and clear the current line to avoid formatting issues
-				fmt.Print("\r\033[K\n")
-				fmt.Printf("Connection closed: %v", err)
+				fmt.Fprint(c.stderr(), "\r\033[K\n")
+				if errors.Is(err, websocket.ErrReadLimit) {
+					fmt.Fprintf(c.stderr(), "Connection closed: server sent a message larger than %d bytes", c.MaxMessageSize)
+				} else {
+					fmt.Fprintf(c.stderr(), "Connection closed: %v", err)
+				}
 				disconnect("connection error")
 				return
 			}
@@ -232,17 +612,229 @@ func (c *Client) Connect() error {
 				disconnect("server sent close message")
 				return
 			}
+			received.Add(int64(len(message)))
+
+			if messageType == websocket.TextMessage && ackResize {
+				if ack, ok := parseResizeAck(message); ok {
+					select {
+					case resizeAcks <- ack:
+					default:
+					}
+					continue
+				}
+			}
+			if messageType == websocket.TextMessage && notices {
+				if text, ok := parseNotice(message); ok {
+					c.emit(NoticeEvent{Text: text})
+					// Shown apart from the session's output when there is somewhere else to show it
+					if c.Stderr != nil {
+						_, err = fmt.Fprintf(c.Stderr, "[linkterm] %s\n", text)
+					} else {
+						_, err = writeOutput(formatNoticeLine(text))
+					}
+					if err != nil {
+						fmt.Fprintf(c.stderr(), "Error writing output: %v", err)
+						disconnect("output error")
+						return
+					}
+					continue
+				}
+			}
+			if messageType == websocket.TextMessage && reportsExit {
+				if code, ok := parseExitStatus(message); ok {
+					closedMu.Lock()
+					closed.ExitCode = code
+					closedMu.Unlock()
+					continue
+				}
+			}
 
-			_, err = os.Stdout.Write(message)
+			if message = runes.align(message); hideEcho != nil {
+				message = hideEcho.filter(message)
+			}
+			if len(message) == 0 {
+				continue
+			}
+			if c.Events != nil || notifier != nil {
+				commands, bell := scanner.scan(message)
+				c.handleCommands(commands, oscNotifier)
+				if bell && notifier != nil &&
+					(c.BellNotify == BellNotifyAlways || focus != nil && !focus.isFocused()) {
+					notifier.notify(c.windowTitle(), "Bell")
+				}
+			}
+			_, err = writeOutput(message)
+			if err == nil && focus != nil && focus.output(message) {
+				// The session turned off the focus reports the client relies on
+				_, err = out.Write(focusReportingOn)
+			}
 			if err != nil {
-				fmt.Printf("Error writing to stdout: %v", err)
+				fmt.Fprintf(c.stderr(), "Error writing output: %v", err)
 				disconnect("output error")
 				return
 			}
 		}
 	}()
 
+	if sendInit {
+		// Typed once the shell knows the terminal size, which programs like htop need
+		select {
+		case <-sized:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(c.InitCommand+"\r")); err != nil && !isClosedErr(err) {
+				c.logger.Warn().Err(err).Msg("Could not send initial command")
+			}
+		case <-done:
+		}
+	}
+
 	// Wait for done signal
 	<-done
-	return nil
+	select {
+	case err := <-rejected:
+		return err
+	default:
+		return nil
+	}
+}
+
+// open dials target with the client's dialer, address family, headers and
+// session, returning the established WebSocket connection and the protocol
+// features the server agreed to
+func (c *Client) open(target string) (*websocket.Conn, features, error) {
+	dialer, header := c.handshake()
+	if c.SessionID != "" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid URL: %w", err)
+		}
+		query := u.Query()
+		query.Set("session", c.SessionID)
+		u.RawQuery = query.Encode()
+		target = u.String()
+	}
+
+	return c.dial(dialer, target, c.withEnv(header))
+}
+
+// handshake returns the dialer and headers for connecting to the server,
+// with the client's buffer, address family and jump host settings
+func (c *Client) handshake() (*websocket.Dialer, http.Header) {
+	// Use custom dialer if set, or the default one, without modifying it
+	dialer := websocket.DefaultDialer
+	if c.dialer != nil {
+		dialer = c.dialer
+	}
+	d := *dialer
+	dialer = &d
+
+	dialer.HandshakeTimeout = c.ConnectTimeout
+	if c.ReadBufferSize > 0 {
+		dialer.ReadBufferSize = c.ReadBufferSize
+	}
+	if c.WriteBufferSize > 0 {
+		dialer.WriteBufferSize = c.WriteBufferSize
+	}
+	if c.WriteBufferPool != nil {
+		dialer.WriteBufferPool = c.WriteBufferPool
+	}
+	if c.ProxyCommand != "" {
+		dialer.Proxy = nil
+		dialer.NetDial = nil
+		dialer.NetDialContext = c.dialProxyCommand
+	} else if dialer.NetDial == nil && dialer.NetDialContext == nil {
+		eyeballs := &happyEyeballsDialer{family: c.Family}
+		dialer.NetDialContext = eyeballs.DialContext
+	}
+
+	// Set User-Agent header: LinkTerm/{version} {SystemInfo}
+	header := c.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("User-Agent", fmt.Sprintf("LinkTerm/%s %s", Version, Platform))
+	features := clientFeatures
+	if c.LowBandwidth {
+		features = append(slices.Clip(features), FeatureLowBandwidth)
+	}
+	header.Set(FeaturesHeader, strings.Join(features, ", "))
+	if c.userinfo != nil && header.Get("Authorization") == "" {
+		header.Set("Authorization", basicAuth(c.userinfo))
+	}
+
+	// Through jump hosts, only the first is reached with the dialer
+	if len(c.Jump) > 0 {
+		first := *dialer
+		dialer.Proxy = nil
+		dialer.NetDial = nil
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return c.dialJumps(ctx, &first, header, addr)
+		}
+	}
+	return dialer, header
+}
+
+// dial connects to target, retrying transient failures up to ConnectRetries
+// times, and returns the features the server agreed to
+func (c *Client) dial(dialer *websocket.Dialer, target string, header http.Header) (*websocket.Conn, features, error) {
+	delay := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		conn, resp, err := dialer.Dial(target, header)
+		if err == nil {
+			if err := c.checkHostKey(target, c.SessionID, resp); err != nil {
+				conn.Close()
+				return nil, nil, err
+			}
+			return conn, parseFeatures(resp.Header), nil
+		}
+
+		var retry bool
+		if resp != nil {
+			class := ErrHandshake
+			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+				class = ErrAuthFailed
+			}
+			// A relay or proxy in front of the server may still be warming up
+			retry = resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable ||
+				resp.StatusCode == http.StatusGatewayTimeout
+			err = fmt.Errorf("failed to connect to terminal server: %w: HTTP %d - %w", class, resp.StatusCode, err)
+		} else if c.proxied || c.ProxyCommand != "" {
+			// Without an HTTP response the failure happened on the way through the proxy
+			retry = true
+			err = fmt.Errorf("failed to connect to terminal server: %w: %w", ErrProxy, err)
+		} else {
+			retry = true
+			err = fmt.Errorf("failed to connect to terminal server: %w: %w", ErrHandshake, err)
+		}
+
+		if !retry || attempt >= c.ConnectRetries {
+			return nil, nil, err
+		}
+		c.logger.Warn().Err(err).Int("attempt", attempt+1).Str("retryIn", delay.String()).Msg("Connection failed, retrying")
+		c.emit(ReconnectingEvent{Attempt: attempt + 1, Delay: delay, Err: err})
+		time.Sleep(delay)
+		if delay *= 2; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
+	}
+}
+
+// keepAlive pings the server every interval until done is closed
+func (c *Client) keepAlive(conn *safeConn, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := conn.WriteControl(websocket.PingMessage, pingPayload(now), now.Add(interval)); err != nil {
+				if !isClosedErr(err) {
+					c.logger.Debug().Err(err).Msg("Failed to send keepalive ping")
+				}
+				return
+			}
+		}
+	}
 }
@@ -0,0 +1,80 @@
+package linkterm
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultTLSCipherSuites are the TLS 1.2 cipher suites offered unless
+// Server.TLSCipherSuites says otherwise: forward secret AEAD suites only.
+// TLS 1.3 always uses its own, which are all of that kind.
+var DefaultTLSCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// knownCurves are the key exchanges ParseTLSCurves knows by name
+var knownCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// ParseTLSVersion parses a TLS version such as "1.3"
+func ParseTLSVersion(name string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(name), "tls") {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", name)
+}
+
+// ParseTLSCipherSuites parses cipher suite names as Go and IANA spell them,
+// such as "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Suites Go considers
+// insecure are refused.
+func ParseTLSCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		i := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool { return strings.EqualFold(suite.Name, name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, tls.CipherSuites()[i].ID)
+	}
+	return ids, nil
+}
+
+// ParseTLSCurves parses key exchange names, X25519, P256, P384 and P521
+func ParseTLSCurves(names []string) ([]tls.CurveID, error) {
+	var ids []tls.CurveID
+	for _, name := range names {
+		i := slices.IndexFunc(knownCurves, func(curve tls.CurveID) bool {
+			return strings.EqualFold(strings.TrimPrefix(curve.String(), "Curve"), strings.ReplaceAll(name, "-", ""))
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("unknown curve %q, expected X25519, P256, P384 or P521", name)
+		}
+		ids = append(ids, knownCurves[i])
+	}
+	return ids, nil
+}
+
+// tlsConfig returns the TLS settings StartTLS and StartACME share
+func (s *Server) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:       s.TLSMinVersion,
+		CipherSuites:     s.TLSCipherSuites,
+		CurvePreferences: s.TLSCurves,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.CipherSuites == nil {
+		config.CipherSuites = DefaultTLSCipherSuites
+	}
+	return config
+}
@@ -0,0 +1,93 @@
+package linkterm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseTLSSettings(t *testing.T) {
+	if v, err := ParseTLSVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("ParseTLSVersion(1.3) = %x, %v", v, err)
+	}
+	for _, name := range []string{"1.0", "1.1", "ssl3", ""} {
+		if _, err := ParseTLSVersion(name); err == nil {
+			t.Errorf("ParseTLSVersion(%q) succeeded, want an error", name)
+		}
+	}
+
+	suites, err := ParseTLSCipherSuites([]string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", "tls_ecdhe_ecdsa_with_aes_256_gcm_sha384"})
+	if err != nil || !slices.Equal(suites, []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}) {
+		t.Errorf("ParseTLSCipherSuites = %x, %v", suites, err)
+	}
+	for _, name := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_RSA_WITH_3DES_EDE_CBC_SHA", "AES"} {
+		if _, err := ParseTLSCipherSuites([]string{name}); err == nil {
+			t.Errorf("ParseTLSCipherSuites(%q) succeeded, want an error", name)
+		}
+	}
+
+	curves, err := ParseTLSCurves([]string{"x25519", "P-256", "P384"})
+	if err != nil || !slices.Equal(curves, []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}) {
+		t.Errorf("ParseTLSCurves = %v, %v", curves, err)
+	}
+	if _, err := ParseTLSCurves([]string{"P224"}); err == nil {
+		t.Error("ParseTLSCurves(P224) succeeded, want an error")
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	cert := selfSignedCert(t)
+	handshake := func(s *Server, client *tls.Config) (uint16, error) {
+		config := s.tlsConfig()
+		config.Certificates = []tls.Certificate{cert}
+		serverConn, clientConn := net.Pipe()
+		defer serverConn.Close()
+		defer clientConn.Close()
+		go tls.Server(serverConn, config).Handshake()
+		client.InsecureSkipVerify = true
+		conn := tls.Client(clientConn, client)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		err := conn.Handshake()
+		return conn.ConnectionState().Version, err
+	}
+
+	if v, err := handshake(&Server{}, &tls.Config{MaxVersion: tls.VersionTLS12}); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("TLS 1.2 client by default: version %x, %v", v, err)
+	}
+	if _, err := handshake(&Server{}, &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA}}); err == nil {
+		t.Error("CBC cipher suite accepted by default")
+	}
+	if _, err := handshake(&Server{TLSMinVersion: tls.VersionTLS13}, &tls.Config{MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Error("TLS 1.2 client accepted with a TLS 1.3 minimum")
+	}
+	if v, err := handshake(&Server{TLSMinVersion: tls.VersionTLS13}, &tls.Config{}); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("TLS 1.3 client with a TLS 1.3 minimum: version %x, %v", v, err)
+	}
+}
+
+// selfSignedCert returns a throwaway certificate for localhost
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,154 @@
+package linkterm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/linksocks/linksocks/linksocks"
+	"github.com/rs/zerolog"
+)
+
+// DefaultTunnel is the tunnel provider used when none is named
+const DefaultTunnel = "linksocks"
+
+// Tunnel reaches a terminal server through a relay when there is no direct
+// route to it. Servers run tunnels in reverse mode and register connector
+// tokens, clients run them in forward mode and dial through their SOCKS5 proxy.
+type Tunnel interface {
+	// Connect starts connecting to the relay in the background
+	Connect(ctx context.Context) error
+	// Ready blocks until the tunnel can carry connections
+	Ready(ctx context.Context) error
+	// SocksAddr returns the local SOCKS5 proxy address of a forward tunnel
+	SocksAddr() string
+	// AddConnector lets clients holding token reach this reverse tunnel
+	AddConnector(token string) (string, error)
+	// Close tears the tunnel down
+	Close() error
+}
+
+// TunnelOptions configures a tunnel created by a TunnelProvider
+type TunnelOptions struct {
+	// URL is the relay server to connect to
+	URL string
+	// Token authenticates with the relay
+	Token string
+	// Reverse is set on the server side, which offers connections instead of making them
+	Reverse bool
+	// Reconnect asks the tunnel to keep retrying the relay whenever its
+	// connection drops, set by servers so they stay reachable
+	Reconnect bool
+	Logger    zerolog.Logger
+}
+
+// TunnelProvider creates a tunnel from options
+type TunnelProvider func(opts TunnelOptions) (Tunnel, error)
+
+var (
+	tunnelsMu sync.RWMutex
+	tunnels   = map[string]TunnelProvider{
+		DefaultTunnel: newLinkSocksTunnel,
+	}
+)
+
+// RegisterTunnel makes a tunnel provider available under name, replacing any
+// provider registered under the same name
+func RegisterTunnel(name string, provider TunnelProvider) {
+	tunnelsMu.Lock()
+	defer tunnelsMu.Unlock()
+	tunnels[name] = provider
+}
+
+// NewTunnel creates a tunnel using the provider registered under name
+func NewTunnel(name string, opts TunnelOptions) (Tunnel, error) {
+	tunnelsMu.RLock()
+	provider, ok := tunnels[name]
+	tunnelsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown tunnel %q, available: %s", name, strings.Join(TunnelNames(), ", "))
+	}
+	return provider(opts)
+}
+
+// TunnelNames returns the names of the registered tunnel providers
+func TunnelNames() []string {
+	tunnelsMu.RLock()
+	defer tunnelsMu.RUnlock()
+	names := make([]string, 0, len(tunnels))
+	for name := range tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// linkSocksTunnel is the Tunnel implemented by a LinkSocks client
+type linkSocksTunnel struct {
+	client    *linksocks.LinkSocksClient
+	socksAddr string
+	// connected is closed once the outcome of connecting is known
+	connected chan struct{}
+	err       error
+}
+
+func newLinkSocksTunnel(opts TunnelOptions) (Tunnel, error) {
+	clientOpt := linksocks.DefaultClientOption().
+		WithWSURL(opts.URL).
+		WithReverse(opts.Reverse).
+		WithReconnect(opts.Reconnect).
+		WithLogger(opts.Logger)
+
+	t := &linkSocksTunnel{connected: make(chan struct{})}
+	if !opts.Reverse {
+		// Find a random available port on localhost for the SOCKS5 proxy
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find available port: %w", err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		clientOpt = clientOpt.WithSocksPort(port)
+		t.socksAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	}
+
+	t.client = linksocks.NewLinkSocksClient(opts.Token, clientOpt)
+	return t, nil
+}
+
+func (t *linkSocksTunnel) Connect(ctx context.Context) error {
+	go func() {
+		t.err = t.client.WaitReady(ctx, 0)
+		close(t.connected)
+	}()
+	return nil
+}
+
+func (t *linkSocksTunnel) Ready(ctx context.Context) error {
+	select {
+	case <-t.connected:
+		if t.err != nil {
+			return fmt.Errorf("failed to connect to linksocks server: %w", t.err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *linkSocksTunnel) SocksAddr() string {
+	return t.socksAddr
+}
+
+func (t *linkSocksTunnel) AddConnector(token string) (string, error) {
+	return t.client.AddConnector(token)
+}
+
+func (t *linkSocksTunnel) Close() error {
+	t.client.Close()
+	return nil
+}
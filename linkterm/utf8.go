@@ -0,0 +1,55 @@
+package linkterm
+
+import "unicode/utf8"
+
+// runeBuffer holds back an incomplete UTF-8 sequence at the end of a chunk of
+// output until the rest of it arrives, so chunks never split a character
+type runeBuffer struct {
+	partial []byte
+}
+
+// align prepends what was held back from the previous chunk to p and holds
+// back any incomplete sequence at its end. Invalid sequences pass unchanged.
+func (b *runeBuffer) align(p []byte) []byte {
+	if len(b.partial) > 0 {
+		p = append(b.partial, p...)
+		b.partial = nil
+	}
+	if n := partialRune(p); n > 0 {
+		b.partial = append([]byte(nil), p[len(p)-n:]...)
+		p = p[:len(p)-n]
+	}
+	return p
+}
+
+// flush returns what is held back, when no more output will follow
+func (b *runeBuffer) flush() []byte {
+	p := b.partial
+	b.partial = nil
+	return p
+}
+
+// trimToRune drops the continuation bytes at the start of p left over from a
+// character cut off in front of it
+func trimToRune(p []byte) []byte {
+	for i := 0; i < utf8.UTFMax-1 && i < len(p); i++ {
+		if utf8.RuneStart(p[i]) {
+			return p[i:]
+		}
+	}
+	return p
+}
+
+// partialRune returns how many bytes at the end of p begin a UTF-8 sequence
+// that is not complete yet
+func partialRune(p []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if utf8.RuneStart(p[len(p)-i]) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
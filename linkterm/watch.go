@@ -0,0 +1,252 @@
+package linkterm
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// watcherQueue bounds the output chunks queued for a viewer, a viewer that
+// falls further behind is disconnected rather than slowing the session down
+const watcherQueue = 256
+
+// watcher is a read-only viewer of a session's output
+type watcher struct {
+	out chan []byte
+	// share is the share link the viewer came with, empty for the
+	// session's own watch link
+	share string
+	// reason tells why out was closed, if not because the session ended
+	reason string
+}
+
+// endReason tells the viewer why it is disconnected, once out is closed
+func (w *watcher) endReason() string {
+	if w.reason != "" {
+		return w.reason
+	}
+	return "Session ended"
+}
+
+// watch registers a viewer coming with the share link share, or with the
+// session's own link if empty, returning the scrollback to replay first. The
+// returned watcher's channel is closed when the session ends, the viewer
+// falls behind or its share link is revoked.
+func (sess *session) watch(share string) (*watcher, []byte) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	w := &watcher{out: make(chan []byte, watcherQueue), share: share}
+	select {
+	case <-sess.done:
+		close(w.out)
+		return w, nil
+	default:
+	}
+	if _, ok := sess.shares[share]; share != "" && !ok {
+		// Revoked since the viewer was let in
+		w.reason = "Share link revoked"
+		close(w.out)
+		return w, nil
+	}
+
+	if sess.watchers == nil {
+		sess.watchers = make(map[*watcher]struct{})
+	}
+	sess.watchers[w] = struct{}{}
+	return w, append([]byte(nil), sess.scrollback...)
+}
+
+// unwatch removes a viewer
+func (sess *session) unwatch(w *watcher) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if _, ok := sess.watchers[w]; ok {
+		delete(sess.watchers, w)
+		close(w.out)
+	}
+}
+
+// broadcast records output in the scrollback and hands it to every viewer,
+// sess.mu must be held
+func (sess *session) broadcast(data []byte) {
+	sess.scrollback = append(sess.scrollback, data...)
+	if over := len(sess.scrollback) - maxBacklog; over > 0 {
+		sess.scrollback = trimToRune(sess.scrollback[over:])
+	}
+
+	if len(sess.watchers) == 0 {
+		return
+	}
+	chunk := append([]byte(nil), data...)
+	for w := range sess.watchers {
+		select {
+		case w.out <- chunk:
+		default:
+			delete(sess.watchers, w)
+			close(w.out)
+		}
+	}
+}
+
+// closeWatchers disconnects every viewer, sess.mu must be held
+func (sess *session) closeWatchers() {
+	for w := range sess.watchers {
+		delete(sess.watchers, w)
+		close(w.out)
+	}
+}
+
+// watchURL returns the link viewers use to watch session id with token,
+// relative to the host r was sent to
+func (s *Server) watchURL(r *http.Request, id, token string) string {
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s%s/session/%s/watch?token=%s", scheme, r.Host, s.Prefix, id, token)
+}
+
+// handleWatch streams a session's output to a read-only viewer, over a
+// WebSocket if the request asks for one and as server-sent events otherwise.
+// Viewers pass the server's authentication first, then show the token.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	clientIP := getClientIP(r)
+	id := r.PathValue("id")
+	identity, err := s.authenticate(r)
+	if err != nil {
+		s.logger.Warn().Str("clientIP", clientIP).Err(err).Msg("Rejected watch request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.authorized(w, r, identity, r.URL.Path) {
+		return
+	}
+
+	s.sessionsMu.Lock()
+	sess := s.sessions[id]
+	s.sessionsMu.Unlock()
+
+	// Unknown sessions and bad tokens look the same, so session IDs cannot be probed
+	token := r.URL.Query().Get("token")
+	var share string
+	if sess != nil && subtle.ConstantTimeCompare([]byte(token), []byte(sess.watchToken)) != 1 {
+		if share = token; !sess.shared(share) {
+			sess = nil
+		}
+	}
+	if sess == nil {
+		s.logger.Warn().Str("clientIP", clientIP).Str("session", id).Msg("Rejected watch request")
+		s.record(AuditEvent{Event: "watch_rejected", Session: id, ClientIP: clientIP, UserAgent: r.UserAgent(), Path: r.URL.Path, Identity: identity})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	s.record(AuditEvent{Event: "watch_start", Session: id, ClientIP: clientIP, UserAgent: r.UserAgent(), Path: r.URL.Path, Identity: identity})
+	if websocket.IsWebSocketUpgrade(r) {
+		s.watchWebSocket(w, r, sess, share, clientIP)
+	} else {
+		s.watchEvents(w, r, sess, share, clientIP)
+	}
+}
+
+// watchWebSocket streams output to a viewer as binary WebSocket messages
+func (s *Server) watchWebSocket(w http.ResponseWriter, r *http.Request, sess *session, share, clientIP string) {
+	wsConn, err := s.upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Error().Str("clientIP", clientIP).Err(err).Msg("Error upgrading to WebSocket")
+		return
+	}
+	conn := newSafeConn(wsConn)
+	defer conn.Close()
+	conn.SetReadLimit(4096)
+
+	viewer, scrollback := sess.watch(share)
+	defer sess.unwatch(viewer)
+	s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Msg("Viewer connected")
+	defer s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Msg("Viewer disconnected")
+
+	// Viewers cannot type, reading only notices when they leave
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if len(scrollback) > 0 {
+		if err := conn.WriteMessage(websocket.BinaryMessage, scrollback); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case data, ok := <-viewer.out:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, viewer.endReason()))
+				return
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// watchEvents streams output to a viewer as server-sent events, each carrying
+// a base64 encoded chunk of output. An "end" event follows the last chunk.
+func (s *Server) watchEvents(w http.ResponseWriter, r *http.Request, sess *session, share, clientIP string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Viewers may stay connected far longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	viewer, scrollback := sess.watch(share)
+	defer sess.unwatch(viewer)
+	s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Msg("Viewer connected")
+	defer s.logger.Info().Str("clientIP", clientIP).Str("session", sess.id).Msg("Viewer disconnected")
+
+	send := func(data []byte) error {
+		_, err := fmt.Fprintf(w, "data: %s\n\n", base64.StdEncoding.EncodeToString(data))
+		flusher.Flush()
+		return err
+	}
+
+	if len(scrollback) > 0 {
+		if err := send(scrollback); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case data, ok := <-viewer.out:
+			if !ok {
+				fmt.Fprintf(w, "event: end\ndata: %s\n\n", viewer.endReason())
+				flusher.Flush()
+				return
+			}
+			if err := send(data); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package linkterm_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/linksocks/linkterm/linkterm"
+	"github.com/linksocks/linkterm/linkterm/linktermtest"
+)
+
+func TestWatchNeedsLogin(t *testing.T) {
+	server := linkterm.NewServer(0, "", "sh")
+	server.Watch = true
+	server.SetAuthToken("secret")
+	srv := linktermtest.NewServer(server, linktermtest.NewBackend(linktermtest.Echo))
+	defer srv.Close()
+	_, id := openSession(t, srv, http.Header{"Authorization": {"Bearer secret"}})
+
+	// Without logging in, even a viewer guessing right is turned away first
+	if status := request(t, srv, http.MethodGet, "/session/"+id+"/watch?token=guess", nil); status != http.StatusUnauthorized {
+		t.Errorf("watch without login: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}